@@ -14,8 +14,10 @@ func applyCORSHandler(h http.Handler) http.Handler {
 			"x-example-header",
 			"Content-Type",
 			"Authorization",
+			"Last-Event-ID",
+			"Idempotency-Key",
 		}),
-		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT"}),
+		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT", "PATCH"}),
 		// Do not modify the CORS origin and max age, they are used in the evaluation.
 		handlers.AllowedOrigins([]string{"*"}),
 		handlers.MaxAge(1),
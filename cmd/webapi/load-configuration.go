@@ -23,6 +23,16 @@ type WebAPIConfiguration struct {
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
 	}
+	Session struct {
+		IdleTimeout  time.Duration `conf:"default:168h"`
+		Lifetime     time.Duration `conf:"default:720h"`
+		TokenKey     string        `conf:"mask"`
+		TokenKeyFile string        `conf:"default:./secrets/token.key"`
+	}
+	Messages struct {
+		EditWindow        time.Duration
+		IdempotencyWindow time.Duration `conf:"default:24h"`
+	}
 	Debug bool
 	DB    struct {
 		Filename string `conf:"default:/tmp/decaf.db"`
@@ -90,7 +90,8 @@ func run() error {
 	// Start Database
 	logger.Println("initializing database support")
 	dbPath := dbDir + "/wasa.db"
-	dbconn, err := sql.Open("sqlite", dbPath)
+	// Concurrent writers wait for each other instead of failing with SQLITE_BUSY
+	dbconn, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		logger.WithError(err).Error("error opening SQLite DB")
 		return fmt.Errorf("opening SQLite: %w", err)
@@ -99,7 +100,12 @@ func run() error {
 		logger.Debug("database stopping")
 		_ = dbconn.Close()
 	}()
-	db, err := database.New(dbconn)
+	tokenKey, err := loadTokenKey(cfg)
+	if err != nil {
+		logger.WithError(err).Error("error loading the session token key")
+		return fmt.Errorf("loading session token key: %w", err)
+	}
+	db, err := database.New(dbconn, tokenKey)
 	if err != nil {
 		logger.WithError(err).Error("error creating AppDatabase")
 		return fmt.Errorf("creating AppDatabase: %w", err)
@@ -119,8 +125,12 @@ func run() error {
 
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:             logger,
+		Database:           db,
+		SessionIdleTimeout: cfg.Session.IdleTimeout,
+		SessionLifetime:    cfg.Session.Lifetime,
+		MessageEditWindow:  cfg.Messages.EditWindow,
+		IdempotencyWindow:  cfg.Messages.IdempotencyWindow,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadTokenKey returns the secret key used to hash session tokens. The key is taken from the configuration if set;
+// otherwise it is read from the key file (Session.TokenKeyFile), which is created with a random key on first start.
+// The file is kept outside the database directory, so a backup of the data alone is not enough to forge or verify
+// tokens.
+func loadTokenKey(cfg WebAPIConfiguration) ([]byte, error) {
+	if cfg.Session.TokenKey != "" {
+		return []byte(cfg.Session.TokenKey), nil
+	}
+
+	keyPath := cfg.Session.TokenKeyFile
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("creating token key directory: %w", err)
+	}
+
+	content, err := os.ReadFile(keyPath)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("invalid token key in %s", keyPath)
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading token key: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating token key: %w", err)
+	}
+	if err := os.WriteFile(keyPath, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("writing token key: %w", err)
+	}
+	return key, nil
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"strings"
 )
 
 // httpRouterHandler is the signature for functions that accepts a reqcontext.RequestContext in addition to those
@@ -35,3 +36,62 @@ func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Re
 		fn(w, r, ps, ctx)
 	}
 }
+
+// wrapAuth is like wrap, but it also requires a valid "Authorization: Bearer <token>" header. Requests without a valid
+// (existing and not expired) session token are rejected with 401 before reaching fn; otherwise the authenticated user
+// and session are stored in reqcontext.RequestContext, the username is added to the request logger, and the user is
+// marked as seen.
+func (rt *_router) wrapAuth(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return rt.wrap(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rt.authenticate(w, r, ps, ctx, token, fn)
+	})
+}
+
+// wrapStream is like wrapAuth, but, without the Authorization header, the session token can also be given with a
+// ticket (see streamTicket) in the "ticket" query parameter.
+func (rt *_router) wrapStream(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return rt.wrap(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		token, ok := bearerToken(r)
+		if ticket := r.URL.Query().Get("ticket"); !ok && ticket != "" {
+			token, ok = rt.redeemStreamTicket(ticket)
+		}
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rt.authenticate(w, r, ps, ctx, token, fn)
+	})
+}
+
+// authenticate calls fn for the user of the session identified by token, or rejects the request with 401 if there is
+// no such session (see wrapAuth).
+func (rt *_router) authenticate(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext, token string, fn httpRouterHandler) {
+	session, err := rt.db.GetSessionByToken(token, rt.sessionIdleTimeout)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting session by token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if session == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ctx.Username = session.Username
+	ctx.UserID = session.UserID
+	ctx.SessionID = session.ID
+	ctx.Logger = ctx.Logger.WithField("username", session.Username)
+	rt.markSeen(ctx)
+
+	fn(w, r, ps, ctx)
+}
+
+// bearerToken returns the token of the "Authorization: Bearer <token>" header of r, and false if there is none.
+func bearerToken(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
@@ -14,23 +14,58 @@ func (rt *_router) Handler() http.Handler {
 	rt.router.GET("/liveness", rt.liveness)
 
 	rt.router.POST("/session", rt.wrap(rt.doLogin))
-	rt.router.GET("/conversations", rt.wrap(rt.getMyConversations))
-	rt.router.PUT("/me/name", rt.wrap(rt.setMyUserName))
-	rt.router.GET("/conversations/:conversationId", rt.wrap(rt.getConversation))
-	rt.router.POST("/messages", rt.wrap(rt.sendMessage))
-	rt.router.DELETE("/messages/:messageId", rt.wrap(rt.deleteMessage))
-	rt.router.POST("/messages/:messageId/comment", rt.wrap(rt.commentMessage))
-	rt.router.DELETE("/messages/:messageId/comment", rt.wrap(rt.uncommentMessage))
-	rt.router.POST("/messages/:messageId/forward", rt.wrap(rt.forwardMessage))
-	rt.router.POST("/groups/:groupId/members", rt.wrap(rt.addToGroup))
-	rt.router.POST("/groups/:groupId/leave", rt.wrap(rt.leaveGroup))
-	rt.router.PUT("/groups/:groupId/name", rt.wrap(rt.setGroupName))
-	rt.router.PUT("/groups/:groupId/photo", rt.wrap(rt.setGroupPhoto))
-	rt.router.GET("/groups/:groupId/photo", rt.wrap(rt.getGroupPhoto))
-	rt.router.PUT("/me/photo", rt.wrap(rt.setMyPhoto))
-	rt.router.GET("/me/photo", rt.wrap(rt.getMyPhoto))
-	rt.router.GET("/users", rt.wrap(rt.searchUsers))
-	rt.router.GET("/users/:username/photo", rt.wrap(rt.getUserPhoto))
+	rt.router.DELETE("/session", rt.wrapAuth(rt.doLogout))
+	rt.router.POST("/session/tickets", rt.wrapAuth(rt.createStreamTicket))
+	rt.router.GET("/me", rt.wrapAuth(rt.getMyProfile))
+	rt.router.PATCH("/me", rt.wrapAuth(rt.updateMyProfile))
+	rt.router.GET("/me/sessions", rt.wrapAuth(rt.getMySessions))
+	rt.router.POST("/me/blocks", rt.wrapAuth(rt.blockUser))
+	rt.router.GET("/me/blocks", rt.wrapAuth(rt.getMyBlocks))
+	rt.router.DELETE("/me/blocks/:username", rt.wrapAuth(rt.unblockUser))
+	rt.router.DELETE("/me/sessions/:sessionId", rt.wrapAuth(rt.revokeMySession))
+	rt.router.GET("/conversations", rt.wrapAuth(rt.getMyConversations))
+	rt.router.PUT("/me/name", rt.wrapAuth(rt.setMyUserName))
+	rt.router.PUT("/me/password", rt.wrapAuth(rt.setMyPassword))
+	rt.router.GET("/conversations/:conversationId", rt.wrapAuth(rt.getConversation))
+	rt.router.POST("/conversations/:conversationId/read", rt.wrapAuth(rt.markConversationRead))
+	rt.router.POST("/messages", rt.wrapAuth(rt.sendMessage))
+	rt.router.GET("/messages/:messageId", rt.wrapAuth(rt.getMessage))
+	rt.router.PUT("/messages/:messageId", rt.wrapAuth(rt.editMessage))
+	rt.router.DELETE("/messages/:messageId", rt.wrapAuth(rt.deleteMessage))
+	rt.router.GET("/messages/:messageId/history", rt.wrapAuth(rt.getMessageHistory))
+	rt.router.GET("/messages/:messageId/replies", rt.wrapAuth(rt.getMessageReplies))
+	rt.router.POST("/attachments", rt.wrapAuth(rt.uploadAttachment))
+	rt.router.GET("/attachments/:attachmentId", rt.wrapAuth(rt.getAttachment))
+	rt.router.PUT("/messages/:messageId/reactions/:emoji", rt.wrapAuth(rt.addReaction))
+	rt.router.DELETE("/messages/:messageId/reactions/:emoji", rt.wrapAuth(rt.removeReaction))
+	rt.router.POST("/messages/:messageId/forward", rt.wrapAuth(rt.forwardMessage))
+	rt.router.POST("/groups", rt.wrapAuth(rt.createGroup))
+	rt.router.GET("/groups/:groupId", rt.wrapAuth(rt.getGroup))
+	rt.router.DELETE("/groups/:groupId", rt.wrapAuth(rt.deleteGroup))
+	rt.router.POST("/groups/:groupId/members", rt.wrapAuth(rt.addToGroup))
+	rt.router.DELETE("/groups/:groupId/members/:username", rt.wrapAuth(rt.removeFromGroup))
+	rt.router.GET("/groups/:groupId/bans", rt.wrapAuth(rt.getGroupBans))
+	rt.router.DELETE("/groups/:groupId/bans/:username", rt.wrapAuth(rt.unbanFromGroup))
+	rt.router.POST("/groups/:groupId/invites", rt.wrapAuth(rt.createGroupInvite))
+	rt.router.GET("/groups/:groupId/invites", rt.wrapAuth(rt.getGroupInvites))
+	rt.router.DELETE("/groups/:groupId/invites/:inviteId", rt.wrapAuth(rt.revokeGroupInvite))
+	rt.router.POST("/invites/:token/join", rt.wrapAuth(rt.joinGroup))
+	rt.router.GET("/groups/:groupId/join-requests", rt.wrapAuth(rt.getJoinRequests))
+	rt.router.POST("/groups/:groupId/join-requests/:username", rt.wrapAuth(rt.approveJoinRequest))
+	rt.router.DELETE("/groups/:groupId/join-requests/:username", rt.wrapAuth(rt.rejectJoinRequest))
+	rt.router.PUT("/groups/:groupId/members/:username/role", rt.wrapAuth(rt.setMemberRole))
+	rt.router.PUT("/groups/:groupId/permissions", rt.wrapAuth(rt.setGroupPermissions))
+	rt.router.POST("/groups/:groupId/leave", rt.wrapAuth(rt.leaveGroup))
+	rt.router.PUT("/groups/:groupId/name", rt.wrapAuth(rt.setGroupName))
+	rt.router.PUT("/groups/:groupId/photo", rt.wrapAuth(rt.setGroupPhoto))
+	rt.router.GET("/groups/:groupId/photo", rt.wrapAuth(rt.getGroupPhoto))
+	rt.router.PUT("/me/photo", rt.wrapAuth(rt.setMyPhoto))
+	rt.router.GET("/me/photo", rt.wrapAuth(rt.getMyPhoto))
+	rt.router.GET("/users", rt.wrapAuth(rt.searchUsers))
+	rt.router.GET("/users/:username", rt.wrapAuth(rt.getUserProfile))
+	rt.router.GET("/users/:username/photo", rt.wrapAuth(rt.getUserPhoto))
+	rt.router.GET("/events", rt.wrapStream(rt.getEvents))
+	rt.router.GET("/ws", rt.wrapStream(rt.getWebSocket))
 
 	return rt.router
 }
@@ -42,6 +42,8 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"sync"
+	"time"
 )
 
 
@@ -52,6 +54,19 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// SessionIdleTimeout is how long a session stays valid without being used (default: 7 days)
+	SessionIdleTimeout time.Duration
+
+	// SessionLifetime is the maximum lifetime of a session, regardless of its use (default: 30 days)
+	SessionLifetime time.Duration
+
+	// MessageEditWindow is how long after sending a message its sender can edit it (default: no limit)
+	MessageEditWindow time.Duration
+
+	// IdempotencyWindow is how long the Idempotency-Key of a message is remembered, so that retried requests do not
+	// send the message again (default: 24 hours)
+	IdempotencyWindow time.Duration
 }
 
 // Router is the package API interface representing an API handler builder
@@ -72,6 +87,15 @@ func New(cfg Config) (Router, error) {
 	if cfg.Database == nil {
 		return nil, errors.New("database is required")
 	}
+	if cfg.SessionIdleTimeout == 0 {
+		cfg.SessionIdleTimeout = 7 * 24 * time.Hour
+	}
+	if cfg.SessionLifetime == 0 {
+		cfg.SessionLifetime = 30 * 24 * time.Hour
+	}
+	if cfg.IdempotencyWindow == 0 {
+		cfg.IdempotencyWindow = 24 * time.Hour
+	}
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
@@ -83,6 +107,16 @@ func New(cfg Config) (Router, error) {
 		router:     router,
 		baseLogger: cfg.Logger,
 		db:         cfg.Database,
+
+		sessionIdleTimeout: cfg.SessionIdleTimeout,
+		sessionLifetime:    cfg.SessionLifetime,
+
+		messageEditWindow:      cfg.MessageEditWindow,
+		idempotencyKeyLifetime: cfg.IdempotencyWindow,
+
+		events:  newEventHub(),
+		tickets: make(map[string]streamTicket),
+		seen:    make(map[string]time.Time),
 	}, nil
 }
 
@@ -94,5 +128,35 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	sessionIdleTimeout time.Duration
+	sessionLifetime    time.Duration
+
+	// messageEditWindow is zero when messages can be edited at any time
+	messageEditWindow time.Duration
+
+	// idempotencyKeyLifetime is how long the idempotency keys of sent messages are remembered
+	idempotencyKeyLifetime time.Duration
+
+	// idempotencyCleanedAt is when the expired idempotency keys were last deleted (see cleanIdempotencyKeys)
+	idempotencyCleanedAt time.Time
+	idempotencyMu        sync.Mutex
+
+	// events dispatches real-time notifications to the connected clients
+	events *eventHub
+
+	// tickets are the unused stream tickets, by ID (see streamTicket)
+	tickets   map[string]streamTicket
+	ticketsMu sync.Mutex
+
+	// websockets tracks the open WebSocket connections, to close them on shutdown. Once closing is set (under
+	// websocketsMu), no more connections are accepted.
+	websockets   sync.WaitGroup
+	websocketsMu sync.Mutex
+	closing      bool
+
+	// seen is when the last-seen time of each user, by ID, was last saved (see markSeen)
+	seen   map[string]time.Time
+	seenMu sync.Mutex
 }
 
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// testClient sends requests to an API router backed by a database in a temporary file.
+type testClient struct {
+	t       *testing.T
+	router  *_router
+	handler http.Handler
+}
+
+func newTestClient(t *testing.T) *testClient {
+	t.Helper()
+	conn, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "wasa.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("opening the database: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn, []byte("test token key"))
+	if err != nil {
+		t.Fatalf("opening the database: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	router, err := New(Config{Logger: logger, Database: db})
+	if err != nil {
+		t.Fatalf("creating the router: %v", err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+	return &testClient{t: t, router: router.(*_router), handler: router.Handler()}
+}
+
+// send sends a request with the given body, authenticated with token unless it is empty.
+func (c *testClient) send(token string, method string, path string, contentType string, body []byte) *httptest.ResponseRecorder {
+	c.t.Helper()
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, r)
+	return w
+}
+
+// do sends a request with body encoded as JSON, or without a body if it is nil.
+func (c *testClient) do(token string, method string, path string, body any) *httptest.ResponseRecorder {
+	c.t.Helper()
+	if body == nil {
+		return c.send(token, method, path, "", nil)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.t.Fatalf("encoding the request body: %v", err)
+	}
+	return c.send(token, method, path, "application/json", data)
+}
+
+// expect sends a request like do, and fails the test if the response status is not the wanted one.
+func (c *testClient) expect(status int, token string, method string, path string, body any) *httptest.ResponseRecorder {
+	c.t.Helper()
+	w := c.do(token, method, path, body)
+	if w.Code != status {
+		c.t.Fatalf("%s %s: got status %d, want %d", method, path, w.Code, status)
+	}
+	return w
+}
+
+// login logs in the user with the given name, creating it if needed, and returns the session token.
+func (c *testClient) login(name string) string {
+	c.t.Helper()
+	var session struct {
+		Identifier string `json:"identifier"`
+	}
+	decode(c.t, c.expect(http.StatusCreated, "", http.MethodPost, "/session", map[string]string{"name": name}), &session)
+	return session.Identifier
+}
+
+// createGroup creates a group with the given members as the user of token, its owner, and returns its ID.
+func (c *testClient) createGroup(token string, members ...string) string {
+	c.t.Helper()
+	var group struct {
+		ID string `json:"groupId"`
+	}
+	decode(c.t, c.expect(http.StatusCreated, token, http.MethodPost, "/groups", map[string]any{"name": "Group", "members": members}), &group)
+	return group.ID
+}
+
+// upload uploads a file with the given name and content as the user of token, and returns the ID of the attachment.
+func (c *testClient) upload(token string, filename string, data []byte) string {
+	c.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err == nil {
+		_, err = part.Write(data)
+	}
+	if err == nil {
+		err = form.Close()
+	}
+	if err != nil {
+		c.t.Fatalf("encoding the form: %v", err)
+	}
+	w := c.send(token, http.MethodPost, "/attachments", form.FormDataContentType(), body.Bytes())
+	if w.Code != http.StatusCreated {
+		c.t.Fatalf("uploading %s: got status %d", filename, w.Code)
+	}
+	var att models.Attachment
+	decode(c.t, w, &att)
+	return att.ID
+}
+
+// decode decodes the JSON body of a response into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// maxAttachmentSize is the maximum size of an uploaded file
+	maxAttachmentSize = 10 * 1024 * 1024
+
+	// maxMessageAttachments is the maximum number of attachments in a message
+	maxMessageAttachments = 10
+
+	// maxFilenameLength is the maximum length, in bytes, of the name of an attachment
+	maxFilenameLength = 255
+
+	// pendingAttachmentLifetime is how long an uploaded file is kept if it is not sent in a message
+	pendingAttachmentLifetime = 24 * time.Hour
+)
+
+// inlineContentTypes are the attachment types that browsers can safely display in the page. Other files, including
+// SVG and HTML, are always served as downloads.
+var inlineContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// uploadAttachment handles POST /attachments
+// The file is sent as multipart/form-data, in the `file` field, with an optional `caption`. The returned attachment
+// ID can then be used in POST /messages.
+func (rt *_router) uploadAttachment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Read the form, leaving some room for the other fields
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+64*1024)
+	if err := r.ParseMultipartForm(maxAttachmentSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer func() { _ = r.MultipartForm.RemoveAll() }()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxAttachmentSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error reading uploaded file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// 3. Build the attachment
+	id, err := uuid.NewV4()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	att := models.Attachment{
+		ID:          id.String(),
+		UploaderID:  username,
+		Filename:    attachmentFilename(header.Filename),
+		ContentType: attachmentContentType(header.Header.Get("Content-Type"), data),
+		Size:        int64(len(data)),
+		Caption:     strings.TrimSpace(r.FormValue("caption")),
+		CreatedAt:   time.Now(),
+	}
+
+	// 4. Save it, and clean up the files uploaded but never sent
+	err = rt.db.CreateAttachment(&att, data)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error saving attachment in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = rt.db.DeletePendingAttachments(att.CreatedAt.Add(-pendingAttachmentLifetime))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error deleting pending attachments from db")
+	}
+
+	// 5. Response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(att)
+}
+
+// getAttachment handles GET /attachments/{attachmentId}
+// Attachments are served to the participants of the conversation of their message, or to their uploader while they
+// are pending.
+func (rt *_router) getAttachment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get the attachment from DB
+	att, err := rt.db.GetAttachment(ps.ByName("attachmentId"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting attachment from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if att == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Check access
+	if att.MessageID == "" {
+		if att.UploaderID != username {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	} else {
+		msg, err := rt.db.GetMessage(att.MessageID)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting message from db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if msg == nil || msg.Deleted || msg.WithheldFrom == username {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		conversation, status, err := rt.participantConversation(msg.ConversationID, username)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting conversation from db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if conversation == nil {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	// 4. Load the content
+	data, err := rt.db.GetAttachmentData(att.ID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting attachment data from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 5. Return the file
+	disposition := "attachment"
+	if inlineContentTypes[att.ContentType] {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", att.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": att.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// pendingAttachments returns the attachments with the given IDs if they can be sent by username in a new message:
+// they must exist, be uploaded by username and not be already sent. Otherwise, it returns the HTTP status to reply
+// with.
+func (rt *_router) pendingAttachments(ids []string, username string) ([]models.Attachment, int, error) {
+	if len(ids) > maxMessageAttachments {
+		return nil, http.StatusBadRequest, nil
+	}
+	attachments := make([]models.Attachment, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return nil, http.StatusBadRequest, nil
+		}
+		seen[id] = true
+
+		att, err := rt.db.GetAttachment(id)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if att == nil || att.UploaderID != username || att.MessageID != "" {
+			return nil, http.StatusBadRequest, nil
+		}
+		attachments = append(attachments, *att)
+	}
+	return attachments, http.StatusOK, nil
+}
+
+// setAttachments sets the attachments of the messages. Deleted messages have none.
+func (rt *_router) setAttachments(messages []models.Message) error {
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		if !m.Deleted {
+			ids = append(ids, m.ID)
+		}
+	}
+	attachments, err := rt.db.GetAttachments(ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].ID]
+	}
+	return nil
+}
+
+// attachmentFilename cleans the name of an uploaded file: only its base name is kept, without control characters.
+func attachmentFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return -1
+		}
+		return c
+	}, name)
+	name = strings.TrimSpace(name)
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	return name
+}
+
+// attachmentContentType returns the media type declared for an uploaded file, or the one detected from its content
+// if the declared one is missing or invalid.
+func attachmentContentType(declared string, data []byte) string {
+	mediaType, _, err := mime.ParseMediaType(declared)
+	if err != nil || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	return mediaType
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Blocked users are not told that they are blocked. Their direct messages to the user who blocked them are accepted,
+// but withheld: only the sender sees them, and they are never delivered. They cannot add that user to groups (as if
+// the user did not exist), do not find them when searching users, and do not see their photo.
+
+// blockUser handles POST /me/blocks
+func (rt *_router) blockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Parse Body
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body.Username = strings.TrimSpace(body.Username)
+	if body.Username == "" || body.Username == username {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 3. Check that the user exists
+	user, err := rt.db.GetUserByName(body.Username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error checking user existence in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 4. Block
+	err = rt.db.BlockUser(username, body.Username, time.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error blocking user in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getMyBlocks handles GET /me/blocks
+func (rt *_router) getMyBlocks(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	// 2. Get Blocked Users
+	blocked, err := rt.db.GetBlockedUsers(ctx.Username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting blocked users from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(blocked)
+}
+
+// unblockUser handles DELETE /me/blocks/:username. The messages withheld while the user was blocked stay withheld.
+func (rt *_router) unblockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	// 2. Unblock
+	unblocked, err := rt.db.UnblockUser(ctx.Username, ps.ByName("username"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error unblocking user in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !unblocked {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withholdMessage sets msg.WithheldFrom if msg is a message of a direct conversation, and the other participant has
+// blocked its sender.
+func (rt *_router) withholdMessage(conversation *models.Conversation, msg *models.Message) error {
+	if conversation.IsGroup || len(conversation.Participants) != 2 || msg.System != nil {
+		return nil
+	}
+	for _, p := range conversation.Participants {
+		if p == msg.Sender {
+			continue
+		}
+		blocked, err := rt.db.HasBlocked(p, msg.Sender)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			msg.WithheldFrom = p
+		}
+	}
+	return nil
+}
+
+// messageRecipients returns the participants of conversation who are notified of msg and of its changes: all of them,
+// except the one it is withheld from.
+func messageRecipients(conversation *models.Conversation, msg *models.Message) []string {
+	if msg.WithheldFrom == "" {
+		return conversation.Participants
+	}
+	return slices.DeleteFunc(slices.Clone(conversation.Participants), func(p string) bool {
+		return p == msg.WithheldFrom
+	})
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/aaitayev/wasa-homework"
+)
+
+func TestBlockUser(t *testing.T) {
+	c := newTestClient(t)
+	alice, bob := c.login("alice"), c.login("bob")
+	if w := c.send(bob, http.MethodPut, "/me/photo", "image/png", []byte("\x89PNG\r\n\x1a\n")); w.Code != http.StatusNoContent {
+		t.Fatalf("setting the photo: got status %d", w.Code)
+	}
+
+	c.expect(http.StatusBadRequest, bob, http.MethodPost, "/me/blocks", map[string]string{"username": "bob"})
+	c.expect(http.StatusNotFound, bob, http.MethodPost, "/me/blocks", map[string]string{"username": "nobody"})
+	c.expect(http.StatusNoContent, bob, http.MethodPost, "/me/blocks", map[string]string{"username": "alice"})
+	var blocks []models.BlockedUser
+	decode(t, c.expect(http.StatusOK, bob, http.MethodGet, "/me/blocks", nil), &blocks)
+	if len(blocks) != 1 || blocks[0].Username != "alice" {
+		t.Errorf("got blocks %+v", blocks)
+	}
+
+	// The direct messages of alice are accepted, but withheld from bob
+	var sent struct {
+		ConversationID string `json:"conversationId"`
+		MessageID      string `json:"messageId"`
+	}
+	decode(t, c.expect(http.StatusCreated, alice, http.MethodPost, "/messages", map[string]string{"recipient": "bob", "text": "hi"}), &sent)
+	for _, tt := range []struct {
+		token string
+		want  bool
+	}{{alice, true}, {bob, false}} {
+		var conversation models.Conversation
+		decode(t, c.expect(http.StatusOK, tt.token, http.MethodGet, "/conversations/"+sent.ConversationID, nil), &conversation)
+		got := slices.ContainsFunc(conversation.Messages, func(m models.Message) bool { return m.ID == sent.MessageID })
+		if got != tt.want {
+			t.Errorf("message visible to the participant: got %t, want %t", got, tt.want)
+		}
+	}
+
+	// To alice, bob looks like a user who does not exist
+	group := c.createGroup(alice)
+	c.expect(http.StatusNotFound, alice, http.MethodPost, "/groups/"+group+"/members", map[string]string{"memberId": "bob"})
+	c.expect(http.StatusNotFound, alice, http.MethodPost, "/groups", map[string]any{"name": "Group", "members": []string{"bob"}})
+	c.expect(http.StatusNotFound, alice, http.MethodGet, "/users/bob/photo", nil)
+	var users []struct {
+		Name string `json:"name"`
+	}
+	decode(t, c.expect(http.StatusOK, alice, http.MethodGet, "/users?search=bob", nil), &users)
+	if len(users) != 0 {
+		t.Errorf("alice found %+v", users)
+	}
+
+	// Once unblocked, bob can be found and added again
+	c.expect(http.StatusNoContent, bob, http.MethodDelete, "/me/blocks/alice", nil)
+	c.expect(http.StatusNotFound, bob, http.MethodDelete, "/me/blocks/alice", nil)
+	c.expect(http.StatusOK, alice, http.MethodGet, "/users/bob/photo", nil)
+	c.expect(http.StatusNoContent, alice, http.MethodPost, "/groups/"+group+"/members", map[string]string{"memberId": "bob"})
+}
+
+func TestWithheldMessage(t *testing.T) {
+	c := newTestClient(t)
+	alice, bob := c.login("alice"), c.login("bob")
+	c.login("carol")
+	c.expect(http.StatusNoContent, bob, http.MethodPost, "/me/blocks", map[string]string{"username": "alice"})
+
+	var sent struct {
+		ConversationID string `json:"conversationId"`
+		MessageID      string `json:"messageId"`
+	}
+	attachment := c.upload(alice, "note.txt", []byte("hello"))
+	decode(t, c.expect(http.StatusCreated, alice, http.MethodPost, "/messages", map[string]any{"recipient": "bob", "text": "hi", "attachments": []string{attachment}}), &sent)
+	message := "/messages/" + sent.MessageID
+	group := c.createGroup(bob, "carol")
+
+	// The message is withheld from bob, whichever way it is reached
+	c.expect(http.StatusNotFound, bob, http.MethodGet, message, nil)
+	c.expect(http.StatusNotFound, bob, http.MethodGet, message+"/history", nil)
+	c.expect(http.StatusNotFound, bob, http.MethodGet, message+"/replies", nil)
+	c.expect(http.StatusNotFound, bob, http.MethodPost, message+"/forward", map[string]string{"conversationId": group})
+	c.expect(http.StatusNotFound, bob, http.MethodGet, "/attachments/"+attachment, nil)
+	c.expect(http.StatusBadRequest, bob, http.MethodPost, "/messages", map[string]string{"conversationId": sent.ConversationID, "text": "what?", "replyTo": sent.MessageID})
+
+	// Alice still sees it
+	c.expect(http.StatusOK, alice, http.MethodGet, message, nil)
+	c.expect(http.StatusOK, alice, http.MethodGet, message+"/history", nil)
+	c.expect(http.StatusOK, alice, http.MethodGet, "/attachments/"+attachment, nil)
+}
@@ -2,7 +2,6 @@ package api
 
 import (
 	"net/http"
-	"strings"
 
 	"github.com/aaitayev/wasa-homework"
 	"github.com/julienschmidt/httprouter"
@@ -11,23 +10,8 @@ import (
 
 // deleteMessage handles DELETE /messages/{messageId}
 func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	username, err := rt.db.GetUserByToken(token)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if username == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
 
 	// 2. Get Message ID
 	messageID := ps.ByName("messageId")
@@ -72,10 +56,14 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 	// 6. Delete (Mark as Deleted)
 	// Spec often implies only sender can delete, but let's stick to participant check + sender check if needed.
 	// Most implementations allow sender to delete their own message.
-	if msg.SenderID != username {
+	if msg.Sender != username {
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	if msg.System != nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
 
 	err = rt.db.DeleteMessage(messageID)
 	if err != nil {
@@ -83,6 +71,7 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	rt.notify(ctx, eventMessageDeleted, eventData{ConversationID: msg.ConversationID, Actor: username, MessageID: messageID}, messageRecipients(conversation, msg))
 
 	// 7. Response
 	w.WriteHeader(http.StatusNoContent)
@@ -4,20 +4,28 @@ import (
 	"encoding/json"
 	"github.com/gofrs/uuid"
 	"net/http"
+	"time"
+	"github.com/aaitayev/wasa-homework"
 	"github.com/aaitayev/wasa-homework"
 	"github.com/julienschmidt/httprouter"
 )
 
 
 // doLogin handles the POST /session endpoint.
-// It reads a JSON body {"name": "..."}.
+// It reads a JSON body {"name": "...", "password": "...", "device": "..."}.
 // If the name is missing or empty, it returns 400.
-// If the user does not exist, it creates a new user and returns a 201 with the identifier.
-// If the user exists, it returns a 201 with the existing identifier.
+// If the user does not exist, it creates a new user, protected by the password if one is given.
+// If the user exists and has a password, the password must match, otherwise it returns 403. If the user exists and has
+// no password, no password must be given (403 as well): name-only accounts are protected with PUT /me/password.
+// In both cases, a new session is created for the device and a 201 with its identifier (bearer token) and the ID of
+// the user is returned.
+// When "device" is missing, the User-Agent header is used as the device label.
 func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	// Parse the request body
 	var user struct {
-		Name string `json:"name"`
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Device   string `json:"device"`
 	}
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
@@ -39,33 +47,96 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 
-	var identifier string
 	if dbUser == nil {
-		// Create a new user
-		ident, err := uuid.NewV4()
+		// Create a new user, with a new ID
+		var passwordHash string
+		if user.Password != "" {
+			if len([]rune(user.Password)) < minPasswordLength {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			passwordHash, err = hashPassword(user.Password)
+			if err != nil {
+				ctx.Logger.WithError(err).Error("error hashing password")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		userID, err := uuid.NewV4()
 		if err != nil {
 			ctx.Logger.WithError(err).Error("error creating uuid")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		identifier = ident.String()
-		err = rt.db.CreateUser(user.Name, identifier)
+		dbUser = &models.User{ID: userID.String(), Name: user.Name, PasswordHash: passwordHash, CreatedAt: time.Now()}
+		err = rt.db.CreateUser(dbUser)
 		if err != nil {
 			ctx.Logger.WithError(err).Error("error creating user in db")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+	} else if dbUser.PasswordHash == "" {
+		// Name-only account: a password would give the false impression that it protects the account
+		if user.Password != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 	} else {
-		identifier = dbUser.Token
+		// Protected account: check the password
+		ok, err := checkPassword(dbUser.PasswordHash, user.Password)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error checking password")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 	}
 
+	// Create a new session for this device
+	sessionID, err := uuid.NewV4()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error creating uuid")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	token, err := uuid.NewV4()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error creating uuid")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	device := user.Device
+	if device == "" {
+		device = r.UserAgent()
+	}
+	now := time.Now()
+	err = rt.db.CreateSession(&models.Session{
+		ID:         sessionID.String(),
+		Token:      token.String(),
+		UserID:     dbUser.ID,
+		Username:   dbUser.Name,
+		Device:     device,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(rt.sessionLifetime),
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error creating session in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
 	// Return the identifier
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(struct {
 		Identifier string `json:"identifier"`
+		UserID     string `json:"userId"`
 	}{
-		Identifier: identifier,
+		Identifier: token.String(),
+		UserID:     dbUser.ID,
 	})
 }
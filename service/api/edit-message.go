@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// editMessage handles PUT /messages/{messageId}
+// Only the sender can edit a message, within the configured edit window. The previous text is kept in the message
+// history.
+func (rt *_router) editMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get message from DB
+	msg, err := rt.db.GetMessage(ps.ByName("messageId"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting message from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if msg == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Check Participation and Ownership
+	conversation, status, err := rt.participantConversation(msg.ConversationID, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if conversation == nil {
+		w.WriteHeader(status)
+		return
+	}
+	if msg.Sender != username {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	now := time.Now()
+	if rt.messageEditWindow > 0 && now.Sub(msg.CreatedAt) > rt.messageEditWindow {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// Deleted messages cannot be edited, nor can forwarded ones, whose text is not the sender's, nor system messages
+	if msg.Deleted || msg.ForwardedFrom != "" || msg.System != nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// 4. Parse Body
+	var body struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if body.Text == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 5. Save the new text, unless nothing changed
+	if body.Text != msg.Text {
+		err = rt.db.EditMessage(msg.ID, body.Text, now)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error editing message in db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		msg.Text = body.Text
+		msg.EditedAt = now
+		rt.notify(ctx, eventMessageEdited, eventData{ConversationID: msg.ConversationID, Actor: username, Message: msg}, messageRecipients(conversation, msg))
+	}
+
+	// 6. Response
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(msg)
+}
+
+// getMessageHistory handles GET /messages/{messageId}/history
+// It returns the versions of the text of the message, oldest first; the last one is the current text.
+func (rt *_router) getMessageHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get message from DB
+	msg, err := rt.db.GetMessage(ps.ByName("messageId"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting message from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if msg == nil || msg.WithheldFrom == username {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Check Participation
+	conversation, status, err := rt.participantConversation(msg.ConversationID, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if conversation == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	// Deleted messages have no visible history
+	if msg.Deleted {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// 4. Load the revisions
+	revisions, err := rt.db.GetMessageRevisions(msg.ID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting message revisions from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// 5. Response
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(revisions)
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+)
+
+// Event types pushed to clients by the events stream (see getEvents)
+const (
+	eventMessageSent     = "message-sent"
+	eventMessageDeleted  = "message-deleted"
+	eventMessageEdited   = "message-edited"
+	eventReactionAdded   = "reaction-added"
+	eventReactionRemoved = "reaction-removed"
+	eventGroupCreated    = "group-created"
+	eventGroupRenamed    = "group-renamed"
+	eventGroupDeleted    = "group-deleted"
+	eventMemberAdded     = "member-added"
+	eventMemberLeft      = "member-left"
+	eventMemberRemoved   = "member-removed"
+	eventJoinRequested   = "join-requested"
+
+	eventMemberRoleChanged       = "member-role-changed"
+	eventGroupPermissionsChanged = "group-permissions-changed"
+
+	eventConversationRead = "conversation-read"
+
+	// eventTyping is ephemeral: it is not numbered nor replayed (see eventHub.signal)
+	eventTyping = "typing"
+)
+
+const (
+	// eventHistorySize is the number of past events kept in memory to be replayed to reconnecting clients
+	eventHistorySize = 1024
+
+	// subscriberBufferSize is the number of events that can be queued for a slow client before it is disconnected
+	subscriberBufferSize = 64
+)
+
+// eventData is the payload of the events. Only the fields relevant to the event type are set.
+type eventData struct {
+	ConversationID string          `json:"conversationId"`
+	Actor          string          `json:"actor"`
+	Message        *models.Message `json:"message,omitempty"`
+	MessageID      string          `json:"messageId,omitempty"`
+	Emoji          string          `json:"emoji,omitempty"`
+	Name           string          `json:"name,omitempty"`
+	Member         string          `json:"member,omitempty"`
+
+	Role        string                   `json:"role,omitempty"`
+	Permissions *models.GroupPermissions `json:"permissions,omitempty"`
+}
+
+// event is a notification for some users. Data is the JSON payload sent to the clients, and Recipients are the IDs of
+// the users it is for: unlike their names, these do not change, so the events of a renamed user keep reaching them
+// and no one else.
+type event struct {
+	ID         uint64
+	Type       string
+	Data       json.RawMessage
+	Recipients []string
+}
+
+// subscriber is a connected client of a user. Events are delivered on C, which is closed when the subscriber is
+// dropped (because it is too slow, or because the hub is closed).
+type subscriber struct {
+	userID string
+	C      chan event
+}
+
+// eventHub dispatches events to the connected clients of their recipients, keyed by user ID. Events have increasing
+// IDs, and the last eventHistorySize events are kept to be replayed to clients reconnecting with the ID of the last
+// event they got.
+//
+// The IDs sent to the clients are prefixed with the epoch of the hub, which differs for each process: the numbering
+// starts again when the server restarts, and an ID from a previous process must not be mistaken for a recent one.
+type eventHub struct {
+	epoch string
+
+	mu          sync.Mutex
+	lastID      uint64
+	history     []event
+	subscribers map[string]map[*subscriber]struct{}
+	closed      bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+// publish sends an event of the given type to the clients of each recipient, given by user ID. data is encoded as
+// JSON.
+func (h *eventHub) publish(eventType string, data any, recipients []string) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+
+	h.lastID++
+	ev := event{ID: h.lastID, Type: eventType, Data: payload, Recipients: recipients}
+	h.history = append(h.history, ev)
+	if len(h.history) > eventHistorySize {
+		h.history = h.history[len(h.history)-eventHistorySize:]
+	}
+
+	for _, userID := range recipients {
+		for sub := range h.subscribers[userID] {
+			select {
+			case sub.C <- ev:
+			default:
+				// The client is not keeping up: drop it, it will reconnect and resume with Last-Event-ID
+				h.remove(sub)
+			}
+		}
+	}
+	return nil
+}
+
+// signal sends an ephemeral event to the clients of each recipient. Ephemeral events have no ID, are not kept for
+// replay and are silently skipped for clients that are not keeping up.
+func (h *eventHub) signal(eventType string, data any, recipients []string) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+
+	ev := event{Type: eventType, Data: payload, Recipients: recipients}
+	for _, userID := range recipients {
+		for sub := range h.subscribers[userID] {
+			select {
+			case sub.C <- ev:
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+// subscribe registers a new client of the user with the given ID. If lastEventID is not empty, the events for the user
+// after that ID are returned to be sent first; complete is false if some of them are no longer available, or if the ID
+// was not issued by this hub (e.g., it comes from before a restart).
+func (h *eventHub) subscribe(userID string, lastEventID string) (sub *subscriber, replay []event, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub = &subscriber{userID: userID, C: make(chan event, subscriberBufferSize)}
+	if h.closed {
+		close(sub.C)
+		return sub, nil, true
+	}
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*subscriber]struct{})
+	}
+	h.subscribers[userID][sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	lastID, ok := h.parseID(lastEventID)
+	if !ok || lastID > h.lastID {
+		return sub, nil, false
+	}
+	complete = len(h.history) == 0 || h.history[0].ID <= lastID+1
+	for _, ev := range h.history {
+		if ev.ID > lastID && slices.Contains(ev.Recipients, userID) {
+			replay = append(replay, ev)
+		}
+	}
+	return sub, replay, complete
+}
+
+// formatID returns the ID of an event as sent to the clients.
+func (h *eventHub) formatID(id uint64) string {
+	return h.epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// parseID parses an event ID sent by a client, and tells whether it was issued by this hub.
+func (h *eventHub) parseID(id string) (uint64, bool) {
+	epoch, n, found := strings.Cut(id, "-")
+	if !found || epoch != h.epoch {
+		return 0, false
+	}
+	parsed, err := strconv.ParseUint(n, 10, 64)
+	return parsed, err == nil
+}
+
+// unsubscribe removes a client from the hub.
+func (h *eventHub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+// remove drops a subscriber and closes its channel. The caller must hold h.mu.
+func (h *eventHub) remove(sub *subscriber) {
+	subs, ok := h.subscribers[sub.userID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subscribers, sub.userID)
+	}
+	close(sub.C)
+}
+
+// connected tells whether the user with the given ID has at least a connected client.
+func (h *eventHub) connected(userID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers[userID]) > 0
+}
+
+// close disconnects all clients. No more events are published after close.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subscribers {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// notify publishes an event to recipients, given by name. Errors are only logged, as the action the event reports has
+// already been performed.
+func (rt *_router) notify(ctx reqcontext.RequestContext, eventType string, data eventData, recipients []string) {
+	userIDs, err := rt.recipientIDs(recipients)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting event recipients from db")
+		return
+	}
+	if err := rt.events.publish(eventType, data, userIDs); err != nil {
+		ctx.Logger.WithError(err).Error("error publishing event")
+	}
+}
+
+// recipientIDs returns the IDs of the users with the given names, to address events to them. Names of users that no
+// longer exist are left out.
+func (rt *_router) recipientIDs(names []string) ([]string, error) {
+	ids, err := rt.db.GetUserIDs(names)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]string, 0, len(ids))
+	for _, name := range names {
+		if id, ok := ids[name]; ok {
+			userIDs = append(userIDs, id)
+		}
+	}
+	return userIDs, nil
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestSubscribeReplay(t *testing.T) {
+	h := newEventHub()
+	defer h.close()
+	for _, recipient := range []string{"alice", "bob", "alice"} {
+		if err := h.publish(eventMessageSent, eventData{}, []string{recipient}); err != nil {
+			t.Fatalf("publishing: %v", err)
+		}
+	}
+
+	// The events after the given one are replayed, only to their recipients
+	_, replay, complete := h.subscribe("alice", h.formatID(1))
+	if !complete || len(replay) != 1 || replay[0].ID != 3 {
+		t.Errorf("got replay %+v (complete: %t), want event 3", replay, complete)
+	}
+	_, replay, complete = h.subscribe("alice", h.formatID(3))
+	if !complete || len(replay) != 0 {
+		t.Errorf("got replay %+v (complete: %t), want none", replay, complete)
+	}
+	_, replay, complete = h.subscribe("alice", "")
+	if !complete || len(replay) != 0 {
+		t.Errorf("got replay %+v (complete: %t) without an ID", replay, complete)
+	}
+}
+
+func TestSubscribeResync(t *testing.T) {
+	previous := newEventHub()
+	previous.epoch = "previous"
+	for range 5 {
+		if err := previous.publish(eventMessageSent, eventData{}, []string{"alice"}); err != nil {
+			t.Fatalf("publishing: %v", err)
+		}
+	}
+	previous.close()
+
+	// After a restart, the numbering starts again: IDs from the previous hub ask for a resync, whether the new hub
+	// has published fewer events than them, more, or none
+	h := newEventHub()
+	defer h.close()
+	for _, published := range []int{0, 2, 8} {
+		for range published - int(h.lastID) {
+			if err := h.publish(eventMessageSent, eventData{}, []string{"alice"}); err != nil {
+				t.Fatalf("publishing: %v", err)
+			}
+		}
+		if _, replay, complete := h.subscribe("alice", previous.formatID(5)); complete || len(replay) != 0 {
+			t.Errorf("after %d events: got replay of %d events (complete: %t), want a resync", published, len(replay), complete)
+		}
+	}
+
+	// So do IDs the hub has not issued
+	for _, id := range []string{h.formatID(9), "5", "garbage"} {
+		if _, _, complete := h.subscribe("alice", id); complete {
+			t.Errorf("last event %q: got complete replay, want a resync", id)
+		}
+	}
+}
+
+func TestSubscribeResyncAfterHistory(t *testing.T) {
+	h := newEventHub()
+	defer h.close()
+	for range eventHistorySize + 2 {
+		if err := h.publish(eventMessageSent, eventData{}, []string{"alice"}); err != nil {
+			t.Fatalf("publishing: %v", err)
+		}
+	}
+
+	// Events older than the history are lost; the first ones kept are replayed anyway
+	_, replay, complete := h.subscribe("alice", h.formatID(1))
+	if complete || len(replay) != eventHistorySize {
+		t.Errorf("got replay of %d events (complete: %t), want %d and a resync", len(replay), complete, eventHistorySize)
+	}
+	_, replay, complete = h.subscribe("alice", h.formatID(2))
+	if !complete || len(replay) != eventHistorySize {
+		t.Errorf("got replay of %d events (complete: %t), want %d", len(replay), complete, eventHistorySize)
+	}
+}
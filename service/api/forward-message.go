@@ -2,10 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strings"
 	"time"
 
+	"github.com/aaitayev/wasa-homework"
 	"github.com/aaitayev/wasa-homework"
 	"github.com/aaitayev/wasa-homework"
 	"github.com/gofrs/uuid"
@@ -15,23 +16,8 @@ import (
 
 // forwardMessage handles POST /messages/{messageId}/forward
 func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	username, err := rt.db.GetUserByToken(token)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if username == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
 
 	// 2. Validate Source Message
 	sourceMessageID := ps.ByName("messageId")
@@ -43,7 +29,7 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if sourceMessage == nil {
+	if sourceMessage == nil || sourceMessage.WithheldFrom == username {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -73,8 +59,8 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 		return
 	}
 
-	// Check if source message is deleted
-	if sourceMessage.Deleted {
+	// Check if source message is deleted. System messages cannot be forwarded either.
+	if sourceMessage.Deleted || sourceMessage.System != nil {
 		w.WriteHeader(http.StatusConflict) // 409 Conflict
 		return
 	}
@@ -92,6 +78,12 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 		return
 	}
 
+	// Retried requests get the response of the first one
+	idempotencyKey, done := rt.checkIdempotencyKey(w, r, ctx)
+	if done {
+		return
+	}
+
 	// 4. Validate Target Conversation
 	targetConversationID := body.ConversationID
 	targetConversation, err := rt.db.GetConversation(targetConversationID)
@@ -113,7 +105,7 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 			break
 		}
 	}
-	if !isTargetParticipant {
+	if !isTargetParticipant || !canPost(targetConversation, username) {
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
@@ -128,20 +120,66 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 	newMessage := models.Message{
 		ID:             newMessageID.String(),
 		ConversationID: targetConversationID,
-		SenderID:       username, // The forwarder is the new sender
+		Sender:         username, // The forwarder is the new sender
 		Text:           sourceMessage.Text,
 		CreatedAt:      time.Now(),
 		ForwardedFrom:  sourceMessageID,
+		IdempotencyKey: idempotencyKey,
+	}
+	err = rt.withholdMessage(targetConversation, &newMessage)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error checking blocked users in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
 	// 6. Save in DB
 	err = rt.db.SaveMessage(&newMessage)
+	if errors.Is(err, database.ErrDuplicateIdempotencyKey) {
+		// A concurrent request with the same key has forwarded the message
+		if !rt.replayIdempotentRequest(w, ctx, username, idempotencyKey) {
+			w.WriteHeader(http.StatusConflict)
+		}
+		return
+	}
 	if err != nil {
 		ctx.Logger.WithError(err).Error("error saving forwarded message in db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	// The forwarded message carries a copy of the attachments
+	sources, err := rt.db.GetAttachments([]string{sourceMessageID})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting attachments from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var sourceIDs, copyIDs []string
+	for _, att := range sources[sourceMessageID] {
+		id, err := uuid.NewV4()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sourceIDs = append(sourceIDs, att.ID)
+		copyIDs = append(copyIDs, id.String())
+	}
+	err = rt.db.CopyAttachments(sourceIDs, copyIDs, newMessage.ID, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error copying attachments in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	attachments, err := rt.db.GetAttachments([]string{newMessage.ID})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting attachments from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	newMessage.Attachments = attachments[newMessage.ID]
+	rt.notify(ctx, eventMessageSent, eventData{ConversationID: targetConversationID, Actor: username, Message: &newMessage}, messageRecipients(targetConversation, &newMessage))
+
 	// 7. Response
 	w.WriteHeader(http.StatusCreated)
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/gofrs/uuid"
+)
+
+func TestForwardAttachments(t *testing.T) {
+	c := newTestClient(t)
+	alice := c.login("alice")
+	c.login("bob")
+	c.login("carol")
+
+	var sent struct {
+		ConversationID string `json:"conversationId"`
+		MessageID      string `json:"messageId"`
+	}
+	first, second := c.upload(alice, "first.txt", []byte("one")), c.upload(alice, "second.txt", []byte("two"))
+	decode(t, c.expect(http.StatusCreated, alice, http.MethodPost, "/messages", map[string]any{"recipient": "bob", "text": "hi", "attachments": []string{first, second}}), &sent)
+	group := c.createGroup(alice, "carol")
+
+	var forwarded struct {
+		MessageID string `json:"messageId"`
+	}
+	decode(t, c.expect(http.StatusCreated, alice, http.MethodPost, "/messages/"+sent.MessageID+"/forward", map[string]string{"conversationId": group}), &forwarded)
+
+	// The copies get new UUIDs and keep the order of the originals
+	var message models.Message
+	decode(t, c.expect(http.StatusOK, alice, http.MethodGet, "/messages/"+forwarded.MessageID, nil), &message)
+	if len(message.Attachments) != 2 {
+		t.Fatalf("got attachments %+v", message.Attachments)
+	}
+	for i, filename := range []string{"first.txt", "second.txt"} {
+		att := message.Attachments[i]
+		if att.Filename != filename {
+			t.Errorf("attachment %d: got %s, want %s", i, att.Filename, filename)
+		}
+		if id, err := uuid.FromString(att.ID); err != nil || id.Version() != uuid.V4 || id.String() != att.ID || att.ID == first || att.ID == second {
+			t.Errorf("attachment %d: got ID %q, want a new UUID", i, att.ID)
+		}
+		c.expect(http.StatusOK, alice, http.MethodGet, "/attachments/"+att.ID, nil)
+	}
+}
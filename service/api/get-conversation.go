@@ -3,32 +3,28 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
+	"strconv"
 
+	"github.com/aaitayev/wasa-homework"
 	"github.com/aaitayev/wasa-homework"
 	"github.com/julienschmidt/httprouter"
 )
 
 
+const (
+	// defaultMessagesPageSize is the number of messages returned by getConversation when no limit is given
+	defaultMessagesPageSize = 50
+
+	// maxMessagesPageSize is the maximum limit accepted by getConversation
+	maxMessagesPageSize = 200
+)
+
 // getConversation handles GET /conversations/{conversationId}
+// Messages are paginated: without parameters the most recent ones are returned; `before` (or `after`) takes a message
+// ID and returns the messages preceding (or following) it. `limit` sets the page size.
 func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	username, err := rt.db.GetUserByToken(token)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if username == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
 
 	// 2. Get Conversation ID
 	conversationID := ps.ByName("conversationId")
@@ -58,8 +54,39 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
-	// 5. Load messages
-	messages, err := rt.db.GetMessages(conversationID)
+	// 5. Read the pagination parameters
+	query := r.URL.Query()
+	before := query.Get("before")
+	after := query.Get("after")
+	if before != "" && after != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit := defaultMessagesPageSize
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxMessagesPageSize {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The cursor must be a message of this conversation
+	if cursor := before + after; cursor != "" {
+		cursorMsg, err := rt.db.GetMessage(cursor)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting cursor message from db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if cursorMsg == nil || cursorMsg.ConversationID != conversationID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 6. Load messages
+	messages, hasMore, err := rt.db.GetMessagesPage(conversationID, username, before, after, limit)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("error getting messages from db")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -67,8 +94,73 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 	}
 	conversation.Messages = messages
 
-	// 6. Response
+	// The user has now received the conversation; show whether their messages have been received and read
+	err = rt.db.MarkConversationsDelivered(username, []string{conversationID})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error marking conversation as delivered in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = rt.fillMessages(conversation.Messages, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting message details from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	conversation.Presence, err = rt.getPresence(conversation.Participants)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting presence from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// previousCursor is set when there are older messages, nextCursor when there are newer ones
+	var previousCursor, nextCursor string
+	if after == "" {
+		if hasMore {
+			previousCursor = messages[0].ID
+		}
+		if before != "" {
+			nextCursor = before
+			if len(messages) > 0 {
+				nextCursor = messages[len(messages)-1].ID
+			}
+		}
+	} else {
+		previousCursor = after
+		if len(messages) > 0 {
+			previousCursor = messages[0].ID
+		}
+		if hasMore {
+			nextCursor = messages[len(messages)-1].ID
+		}
+	}
+
+	// 7. Response
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(conversation)
+	_ = json.NewEncoder(w).Encode(struct {
+		*models.Conversation
+		PreviousCursor string `json:"previousCursor,omitempty"`
+		NextCursor     string `json:"nextCursor,omitempty"`
+	}{
+		Conversation:   conversation,
+		PreviousCursor: previousCursor,
+		NextCursor:     nextCursor,
+	})
 }
 
+// fillMessages sets the details of the messages that are not stored with them, as seen by username: delivery
+// status, reactions, quoted messages and attachments.
+func (rt *_router) fillMessages(messages []models.Message, username string) error {
+	if err := rt.setMessageStatuses(messages, username); err != nil {
+		return err
+	}
+	if err := rt.setReactions(messages); err != nil {
+		return err
+	}
+	if err := rt.setQuotes(messages); err != nil {
+		return err
+	}
+	return rt.setAttachments(messages)
+}
@@ -3,88 +3,90 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"sort"
-	"strings"
-	"time"
+	"strconv"
 
+	"github.com/aaitayev/wasa-homework"
 	"github.com/aaitayev/wasa-homework"
 	"github.com/julienschmidt/httprouter"
 )
 
+const (
+	// defaultConversationsPageSize is the number of conversations returned by getMyConversations when no limit is given
+	defaultConversationsPageSize = 50
 
-type ConversationSummary struct {
-	ID              string    `json:"id"`
-	IsGroup         bool      `json:"isGroup"`
-	Name            string    `json:"name"`
-	Participants    []string  `json:"participants"`
-	LastMessageAt   time.Time `json:"lastMessageAt"`
-	LastMessageText string    `json:"lastMessageText"`
-}
+	// maxConversationsPageSize is the maximum limit accepted by getMyConversations
+	maxConversationsPageSize = 200
+)
 
 // getMyConversations handles the GET /conversations endpoint.
+// Conversations are returned newest first, paginated with the `limit` and `offset` query parameters.
 func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// Extract the token from the Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+	username := ctx.Username
+
+	// Read the pagination parameters
+	query := r.URL.Query()
+	limit := defaultConversationsPageSize
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxConversationsPageSize {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	offset := 0
+	if o := query.Get("offset"); o != "" {
+		var err error
+		offset, err = strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
 
-	// Validate the token
-	username, err := rt.db.GetUserByToken(token)
+	// Get the conversation summaries for the user from DB
+	summaries, err := rt.db.GetConversationSummaries(username, limit, offset)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
+		ctx.Logger.WithError(err).Error("error getting conversation summaries from db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if username == "" {
-		w.WriteHeader(http.StatusUnauthorized)
+
+	// The last messages of these conversations have now been delivered to the user
+	ids := make([]string, 0, len(summaries))
+	for _, s := range summaries {
+		ids = append(ids, s.ID)
+	}
+	err = rt.db.MarkConversationsDelivered(username, ids)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error marking conversations as delivered in db")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// Get the conversations for the user from DB
-	dbConvs, err := rt.db.GetUserConversations(username)
+	// Show the presence of the participants
+	var participants []string
+	for _, s := range summaries {
+		participants = append(participants, s.Participants...)
+	}
+	presence, err := rt.getPresence(participants)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user conversations from db")
+		ctx.Logger.WithError(err).Error("error getting presence from db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	summaries := make([]ConversationSummary, 0, len(dbConvs))
-	for _, conv := range dbConvs {
-		// Load messages to get the last one
-		messages, err := rt.db.GetMessages(conv.ID)
-		if err != nil {
-			ctx.Logger.WithError(err).Error("error getting messages for summary")
-			continue // Skip or handle error
+	for i := range summaries {
+		for _, p := range summaries[i].Participants {
+			if pr, ok := presence[p]; ok {
+				if summaries[i].Presence == nil {
+					summaries[i].Presence = make(map[string]models.Presence)
+				}
+				summaries[i].Presence[p] = pr
+			}
 		}
-
-		var lastMsgAt time.Time
-		var lastMsgText string
-		if len(messages) > 0 {
-			lastMsg := messages[len(messages)-1]
-			lastMsgAt = lastMsg.CreatedAt
-			lastMsgText = lastMsg.Text
-		}
-
-		summaries = append(summaries, ConversationSummary{
-			ID:              conv.ID,
-			IsGroup:         conv.IsGroup,
-			Name:            conv.Name,
-			Participants:    conv.Participants,
-			LastMessageAt:   lastMsgAt,
-			LastMessageText: lastMsgText,
-		})
 	}
 
-	// Sort reverse-chronological (newest first)
-	sort.Slice(summaries, func(i, j int) bool {
-		return summaries[i].LastMessageAt.After(summaries[j].LastMessageAt)
-	})
-
 	// Return the conversations
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(summaries)
 }
-
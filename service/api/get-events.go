@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// eventsHeartbeatInterval is how often a keep-alive comment is sent on idle event streams
+	eventsHeartbeatInterval = 30 * time.Second
+
+	// eventsWriteTimeout is the deadline for each write on an event stream
+	eventsWriteTimeout = 10 * time.Second
+)
+
+// getEvents handles GET /events. It streams the events of the user as Server-Sent Events, until the client
+// disconnects or the server shuts down. Clients reconnecting with the "Last-Event-ID" header (or the "lastEventId"
+// query parameter) receive the events they missed; if some of them are no longer available (or the ID is from before
+// a server restart), a "resync" event is sent first, and the client should reload its data. Ephemeral events (like
+// typing indicators) have no ID and are never replayed.
+func (rt *_router) getEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// The stream outlives the server write timeout: deadlines are set for each write instead
+	rc := http.NewResponseController(w)
+
+	// Browsers cannot set the header when opening a new EventSource (e.g., with a new ticket): the query parameter is
+	// accepted too
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	sub, replay, complete := rt.events.subscribe(ctx.UserID, lastEventID)
+	// The user is last seen when they disconnect
+	defer rt.saveLastSeen(ctx)
+	defer rt.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(format string, args ...any) error {
+		_ = rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	writeEvent := func(ev event) error {
+		if ev.ID == 0 {
+			// Ephemeral event
+			return write("event: %s\ndata: %s\n\n", ev.Type, ev.Data)
+		}
+		return write("id: %s\nevent: %s\ndata: %s\n\n", rt.events.formatID(ev.ID), ev.Type, ev.Data)
+	}
+
+	if !complete {
+		if err := write("event: resync\ndata: {}\n\n"); err != nil {
+			return
+		}
+	}
+	for _, ev := range replay {
+		if err := writeEvent(ev); err != nil {
+			return
+		}
+	}
+	if err := write(": connected\n\n"); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				// Dropped by the hub (slow client or server shutdown)
+				return
+			}
+			if err := writeEvent(ev); err != nil {
+				ctx.Logger.WithError(err).Debug("error writing event, closing stream")
+				return
+			}
+		case <-heartbeat.C:
+			if err := write(": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -2,7 +2,6 @@ package api
 
 import (
 	"net/http"
-	"strings"
 
 	"github.com/aaitayev/wasa-homework"
 	"github.com/julienschmidt/httprouter"
@@ -10,23 +9,7 @@ import (
 
 // getGroupPhoto handles GET /groups/:groupId/photo
 func (rt *_router) getGroupPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	username, err := rt.db.GetUserByToken(token)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if username == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	username := ctx.Username
 
 	groupID := ps.ByName("groupId")
 	if groupID == "" {
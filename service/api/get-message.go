@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// getMessage handles GET /messages/{messageId}
+func (rt *_router) getMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// httprouter cannot register GET /messages/search along with the routes under /messages/:messageId, so the
+	// search is dispatched from here. Message IDs are UUIDs, hence there is no ambiguity.
+	if ps.ByName("messageId") == "search" {
+		rt.searchMessages(w, r, ps, ctx)
+		return
+	}
+
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get message from DB
+	msg, err := rt.db.GetMessage(ps.ByName("messageId"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting message from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if msg == nil || msg.WithheldFrom == username {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Check Participation
+	conversation, status, err := rt.participantConversation(msg.ConversationID, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if conversation == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	// 4. Add the details
+	messages := []models.Message{*msg}
+	err = rt.fillMessages(messages, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting message details from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// 5. Response
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(messages[0])
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// quoteLength is the maximum length, in characters, of the text quoted by a reply
+const quoteLength = 100
+
+// getMessageReplies handles GET /messages/{messageId}/replies
+// It returns the messages replying to the message, in chronological order.
+func (rt *_router) getMessageReplies(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get message from DB
+	msg, err := rt.db.GetMessage(ps.ByName("messageId"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting message from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if msg == nil || msg.WithheldFrom == username {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Check Participation
+	conversation, status, err := rt.participantConversation(msg.ConversationID, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if conversation == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	// 4. Load the replies
+	replies, err := rt.db.GetReplies(msg.ID, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting replies from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = rt.fillMessages(replies, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting message details from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// 5. Response
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(replies)
+}
+
+// setQuotes sets the preview of the message each reply answers
+func (rt *_router) setQuotes(messages []models.Message) error {
+	var ids []string
+	for _, m := range messages {
+		if m.ReplyTo != "" {
+			ids = append(ids, m.ReplyTo)
+		}
+	}
+	quotes, err := rt.db.GetQuotes(ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		q, ok := quotes[messages[i].ReplyTo]
+		if !ok {
+			continue
+		}
+		q.Text = quoteSnippet(q.Text)
+		messages[i].Quote = &q
+	}
+	return nil
+}
+
+// quoteSnippet shortens text to quoteLength characters
+func quoteSnippet(text string) string {
+	if utf8.RuneCountInString(text) <= quoteLength {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:quoteLength-1]) + "…"
+}
@@ -2,7 +2,6 @@ package api
 
 import (
 	"net/http"
-	"strings"
 
 	"github.com/aaitayev/wasa-homework"
 	"github.com/julienschmidt/httprouter"
@@ -10,23 +9,21 @@ import (
 
 // getUserPhoto handles GET /users/:username/photo
 func (rt *_router) getUserPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
+	username := ps.ByName("username")
+	if username == "" {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	_, err := rt.db.GetUserByToken(token)
+
+	// Users who have blocked the requester appear to have no photo
+	blocked, err := rt.db.HasBlocked(username, ctx.Username)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
+		ctx.Logger.WithError(err).Error("error checking blocked users in db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	username := ps.ByName("username")
-	if username == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	if blocked {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// groupRoles lists the roles of the group members, from the least to the most privileged
+var groupRoles = []string{models.RoleMember, models.RoleAdmin, models.RoleOwner}
+
+// hasRole returns whether username is a participant of conversation with at least the given role. In direct
+// conversations, every participant has all the roles.
+func hasRole(conversation *models.Conversation, username string, role string) bool {
+	if !slices.Contains(conversation.Participants, username) {
+		return false
+	}
+	if !conversation.IsGroup {
+		return true
+	}
+	return roleRank(conversation.Roles[username]) >= roleRank(role)
+}
+
+// roleRank returns the privilege level of a group role: higher ranks have more privileges
+func roleRank(role string) int {
+	return slices.Index(groupRoles, role)
+}
+
+// canPost returns whether username can send messages to conversation
+func canPost(conversation *models.Conversation, username string) bool {
+	return hasRole(conversation, username, conversation.Permissions.Post)
+}
+
+// successor returns the member who becomes the owner of a group when its owner leaves: the oldest admin, or else the
+// oldest member. It returns an empty string if the owner is the only member.
+func successor(group *models.Conversation, owner string) string {
+	var oldestMember string
+	for _, p := range group.Participants {
+		if p == owner {
+			continue
+		}
+		if group.Roles[p] == models.RoleAdmin {
+			return p
+		}
+		if oldestMember == "" {
+			oldestMember = p
+		}
+	}
+	return oldestMember
+}
+
+// setMemberRole handles PUT /groups/:groupId/members/:username/role. Only the owner can change the roles; making
+// another member the owner transfers the ownership, and the previous owner becomes an admin.
+func (rt *_router) setMemberRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Group
+	groupID := ps.ByName("groupId")
+	group, err := rt.db.GetConversation(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if group == nil || !group.IsGroup {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Check Ownership
+	if !hasRole(group, username, models.RoleOwner) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// 4. Parse Body
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(groupRoles, body.Role) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 5. Check Member. The owner cannot change their own role, except by transferring the ownership.
+	member := ps.ByName("username")
+	if !slices.Contains(group.Participants, member) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if member == username {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// 6. Set Role
+	if body.Role == models.RoleOwner {
+		err = rt.db.TransferOwnership(groupID, username, member)
+	} else {
+		err = rt.db.SetParticipantRole(groupID, member, body.Role)
+	}
+	if errors.Is(err, database.ErrOwnershipChanged) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error setting member role in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.notify(ctx, eventMemberRoleChanged, eventData{ConversationID: groupID, Actor: username, Member: member, Role: body.Role}, group.Participants)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setGroupPermissions handles PUT /groups/:groupId/permissions. Only the owner can change the permissions.
+func (rt *_router) setGroupPermissions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Group
+	groupID := ps.ByName("groupId")
+	group, err := rt.db.GetConversation(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if group == nil || !group.IsGroup {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Check Ownership
+	if !hasRole(group, username, models.RoleOwner) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// 4. Parse Body. Missing permissions are left unchanged.
+	permissions := group.Permissions
+	if err := json.NewDecoder(r.Body).Decode(&permissions); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, role := range []string{permissions.Rename, permissions.ChangePhoto, permissions.AddMembers, permissions.Post} {
+		if !slices.Contains(groupRoles, role) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 5. Set Permissions
+	err = rt.db.SetGroupPermissions(groupID, permissions)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error setting group permissions in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.notify(ctx, eventGroupPermissionsChanged, eventData{ConversationID: groupID, Actor: username, Permissions: &permissions}, group.Participants)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(permissions)
+}
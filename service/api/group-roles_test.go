@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aaitayev/wasa-homework"
+)
+
+func TestHasRole(t *testing.T) {
+	group := &models.Conversation{
+		IsGroup:      true,
+		Participants: []string{"owner", "admin", "member"},
+		Roles:        map[string]string{"owner": models.RoleOwner, "admin": models.RoleAdmin, "member": models.RoleMember},
+	}
+	direct := &models.Conversation{Participants: []string{"alice", "bob"}}
+
+	for _, tt := range []struct {
+		conversation *models.Conversation
+		username     string
+		role         string
+		want         bool
+	}{
+		{group, "owner", models.RoleOwner, true},
+		{group, "owner", models.RoleMember, true},
+		{group, "admin", models.RoleAdmin, true},
+		{group, "admin", models.RoleOwner, false},
+		{group, "member", models.RoleMember, true},
+		{group, "member", models.RoleAdmin, false},
+		{group, "stranger", models.RoleMember, false},
+		{direct, "alice", models.RoleOwner, true},
+		{direct, "stranger", models.RoleMember, false},
+	} {
+		if got := hasRole(tt.conversation, tt.username, tt.role); got != tt.want {
+			t.Errorf("hasRole(%v, %q, %q) = %t, want %t", tt.conversation.Participants, tt.username, tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestGroupPermissions(t *testing.T) {
+	c := newTestClient(t)
+	owner, admin, member := c.login("owner"), c.login("admin"), c.login("member")
+	group := c.createGroup(owner, "admin", "member")
+	base := "/groups/" + group
+
+	// Only the owner changes the roles and the permissions
+	c.expect(http.StatusForbidden, member, http.MethodPut, base+"/members/admin/role", map[string]string{"role": models.RoleAdmin})
+	c.expect(http.StatusNoContent, owner, http.MethodPut, base+"/members/admin/role", map[string]string{"role": models.RoleAdmin})
+	c.expect(http.StatusForbidden, admin, http.MethodPut, base+"/permissions", map[string]string{"rename": models.RoleMember})
+	c.expect(http.StatusBadRequest, owner, http.MethodPut, base+"/permissions", map[string]string{"rename": "nobody"})
+
+	// By default, admins rename the group and members post
+	c.expect(http.StatusForbidden, member, http.MethodPut, base+"/name", map[string]string{"name": "Renamed"})
+	c.expect(http.StatusNoContent, admin, http.MethodPut, base+"/name", map[string]string{"name": "Renamed"})
+	c.expect(http.StatusCreated, member, http.MethodPost, "/messages", map[string]string{"conversationId": group, "text": "hi"})
+
+	// The owner can restrict posting to admins, and open renaming to members
+	c.expect(http.StatusOK, owner, http.MethodPut, base+"/permissions", map[string]string{"post": models.RoleAdmin, "rename": models.RoleMember})
+	c.expect(http.StatusForbidden, member, http.MethodPost, "/messages", map[string]string{"conversationId": group, "text": "hi"})
+	c.expect(http.StatusCreated, admin, http.MethodPost, "/messages", map[string]string{"conversationId": group, "text": "hi"})
+	c.expect(http.StatusNoContent, member, http.MethodPut, base+"/name", map[string]string{"name": "Renamed again"})
+
+	// Users outside the group have no role in it
+	stranger := c.login("stranger")
+	c.expect(http.StatusForbidden, stranger, http.MethodPut, base+"/name", map[string]string{"name": "Mine"})
+}
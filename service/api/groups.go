@@ -2,34 +2,219 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 )
 
+// maxGroupPhotoSize is the maximum size of a group photo, as for PUT /groups/:groupId/photo
+const maxGroupPhotoSize = 5 * 1024 * 1024
 
-// addToGroup handles POST /groups/{groupId}/members
-func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
+// createGroup handles POST /groups
+func (rt *_router) createGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Parse Body. The photo is base64 encoded, so the body can be larger than the photo.
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxGroupPhotoSize)
+	var body struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Members     []string `json:"members"`
+		Photo       []byte   `json:"photo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 3. Validate Photo
+	var photoContentType string
+	if body.Photo != nil {
+		if len(body.Photo) > maxGroupPhotoSize {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		photoContentType = http.DetectContentType(body.Photo)
+		if photoContentType != "image/jpeg" && photoContentType != "image/png" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	// 4. Validate Members. The creator is always a member.
+	participants := []string{username}
+	for _, m := range body.Members {
+		m = strings.TrimSpace(m)
+		if m != "" && !slices.Contains(participants, m) {
+			participants = append(participants, m)
+		}
+	}
+	missing, err := rt.db.GetMissingUsers(participants[1:])
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error checking user existence in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Users who have blocked the creator cannot be added, as if they did not exist
+	blocking, err := rt.db.GetBlockingUsers(username, participants[1:])
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error checking blocked users in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(blocking) > 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 5. Create Group
+	groupID, err := uuid.NewV4()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error generating group ID")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	group := models.Conversation{
+		ID:           groupID.String(),
+		Participants: participants,
+		IsGroup:      true,
+		Name:         body.Name,
+		Description:  body.Description,
+		CreatedBy:    username,
+		CreatedAt:    time.Now(),
+	}
+	err = rt.db.CreateGroup(&group, body.Photo, photoContentType)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error creating group in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.notify(ctx, eventGroupCreated, eventData{ConversationID: group.ID, Actor: username, Name: group.Name}, participants)
+	err = rt.postSystemMessage(ctx, &group, username, models.SystemEvent{Kind: models.SystemGroupCreated, Name: group.Name})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error saving system message in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// 6. Response, with the default roles and permissions
+	created, err := rt.db.GetConversation(group.ID)
+	if err != nil || created == nil {
+		ctx.Logger.WithError(err).Error("error getting created group from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(groupInfo(created))
+}
+
+// getGroup handles GET /groups/:groupId
+func (rt *_router) getGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Group
+	group, err := rt.db.GetConversation(ps.ByName("groupId"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if group == nil || !group.IsGroup {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	username, err := rt.db.GetUserByToken(token)
+
+	// 3. Check Requester Participation
+	if !slices.Contains(group.Participants, username) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(groupInfo(group))
+}
+
+// deleteGroup handles DELETE /groups/:groupId. Only the owner of the group can delete it, with all its messages.
+func (rt *_router) deleteGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Group
+	groupID := ps.ByName("groupId")
+	group, err := rt.db.GetConversation(groupID)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if username == "" {
-		w.WriteHeader(http.StatusUnauthorized)
+	if group == nil || !group.IsGroup {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	// 3. Check Ownership
+	if !hasRole(group, username, models.RoleOwner) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// 4. Delete Group
+	err = rt.db.DeleteConversation(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error deleting group from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.notify(ctx, eventGroupDeleted, eventData{ConversationID: groupID, Actor: username}, group.Participants)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// groupInfo returns the metadata of a group conversation
+func groupInfo(group *models.Conversation) models.Group {
+	members := group.Participants
+	if members == nil {
+		members = []string{}
+	}
+	return models.Group{
+		ID:          group.ID,
+		Name:        group.Name,
+		Description: group.Description,
+		CreatedBy:   group.CreatedBy,
+		CreatedAt:   group.CreatedAt,
+		Members:     members,
+		MemberCount: len(members),
+		Roles:       group.Roles,
+		Permissions: group.Permissions,
+	}
+}
+
+// addToGroup handles POST /groups/{groupId}/members
+func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
 	// 2. Get Group ID
 	groupID := ps.ByName("groupId")
 	conversation, err := rt.db.GetConversation(groupID)
@@ -55,6 +240,10 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	if !hasRole(conversation, username, conversation.Permissions.AddMembers) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 
 	// 4. Parse Body
 	var body struct {
@@ -81,36 +270,59 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	// 6. Add Member
-	err = rt.db.AddParticipant(groupID, body.MemberID)
+	// Users who have blocked the requester cannot be added by them, as if they did not exist
+	blocked, err := rt.db.HasBlocked(body.MemberID, username)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("error adding participant to group in db")
+		ctx.Logger.WithError(err).Error("error checking blocked users in db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if blocked {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 
-	w.WriteHeader(http.StatusNoContent)
-}
+	// Banned users must be unbanned first
+	banned, err := rt.db.IsBannedFromGroup(groupID, body.MemberID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error checking group ban in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if banned {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
 
-// leaveGroup handles POST /groups/{groupId}/leave
-func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
+	// 6. Add Member, recording the addition in the conversation. Adding a member again changes nothing, and is not
+	// recorded.
+	conversation.Participants = append(slices.Clone(conversation.Participants), body.MemberID)
+	record, err := rt.systemMessage(conversation, username, models.SystemEvent{Kind: models.SystemMemberAdded, Target: body.MemberID})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error preparing system message")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	username, err := rt.db.GetUserByToken(token)
+	added, err := rt.db.AddParticipant(groupID, body.MemberID, record)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
+		ctx.Logger.WithError(err).Error("error adding participant to group in db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if username == "" {
-		w.WriteHeader(http.StatusUnauthorized)
+	if !added {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	rt.notify(ctx, eventMemberAdded, eventData{ConversationID: groupID, Actor: username, Member: body.MemberID}, conversation.Participants)
+	rt.notifyMessage(ctx, conversation, record)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// leaveGroup handles POST /groups/{groupId}/leave
+func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
 
 	// 2. Get Group ID
 	groupID := ps.ByName("groupId")
@@ -138,36 +350,57 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	// Remove Participant
-	err = rt.db.RemoveParticipant(groupID, username)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("error removing participant from db")
-		w.WriteHeader(http.StatusInternalServerError)
+	// 4. Parse Body. It is optional, and only used when the owner leaves.
+	var body struct {
+		NewOwner string `json:"newOwner"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
-}
+	// 5. The owner hands the group over to the chosen member, or else to the oldest admin or member
+	var newOwner string
+	if conversation.Roles[username] == models.RoleOwner {
+		if body.NewOwner != "" {
+			if body.NewOwner == username || !slices.Contains(conversation.Participants, body.NewOwner) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			newOwner = body.NewOwner
+		} else {
+			newOwner = successor(conversation, username)
+		}
+	}
 
-// setGroupName handles PUT /groups/{groupId}/name
-func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
+	// 6. Remove Participant, recording the departure in the conversation for the remaining members
+	remaining := *conversation
+	remaining.Participants = slices.DeleteFunc(slices.Clone(conversation.Participants), func(p string) bool { return p == username })
+	record, err := rt.systemMessage(&remaining, username, models.SystemEvent{Kind: models.SystemMemberLeft})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error preparing system message")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	username, err := rt.db.GetUserByToken(token)
+	err = rt.db.LeaveGroup(groupID, username, newOwner, record)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
+		ctx.Logger.WithError(err).Error("error removing participant from db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if username == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+	rt.notify(ctx, eventMemberLeft, eventData{ConversationID: groupID, Actor: username, Member: username}, conversation.Participants)
+	if newOwner != "" {
+		rt.notify(ctx, eventMemberRoleChanged, eventData{ConversationID: groupID, Actor: username, Member: newOwner, Role: models.RoleOwner}, conversation.Participants)
 	}
+	rt.notifyMessage(ctx, &remaining, record)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setGroupName handles PUT /groups/{groupId}/name
+func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
 
 	// 2. Get Group ID
 	groupID := ps.ByName("groupId")
@@ -194,6 +427,10 @@ func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httpr
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	if !hasRole(conversation, username, conversation.Permissions.Rename) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 
 	// 4. Parse Body
 	var body struct {
@@ -208,13 +445,26 @@ func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httpr
 		return
 	}
 
-	// 5. Update Name
-	err = rt.db.UpdateConversationName(groupID, body.Name)
+	// 5. Update Name, recording the change in the conversation. Setting the same name changes nothing, and is not
+	// recorded.
+	if body.Name == conversation.Name {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	record, err := rt.systemMessage(conversation, username, models.SystemEvent{Kind: models.SystemGroupRenamed, Name: body.Name})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error preparing system message")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = rt.db.UpdateConversationName(groupID, body.Name, record)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("error updating conversation name in db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	rt.notify(ctx, eventGroupRenamed, eventData{ConversationID: groupID, Actor: username, Name: body.Name}, conversation.Participants)
+	rt.notifyMessage(ctx, conversation, record)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/aaitayev/wasa-homework"
+)
+
+func TestGroupChangesRecorded(t *testing.T) {
+	c := newTestClient(t)
+	owner, member := c.login("owner"), c.login("member")
+	c.login("other")
+	group := c.createGroup(owner, "member")
+	base := "/groups/" + group
+
+	records := func() []string {
+		var conversation models.Conversation
+		decode(t, c.expect(http.StatusOK, owner, http.MethodGet, "/conversations/"+group, nil), &conversation)
+		var kinds []string
+		for _, m := range conversation.Messages {
+			if m.System != nil {
+				kinds = append(kinds, m.System.Kind)
+			}
+		}
+		return kinds
+	}
+	before := len(records())
+
+	// Each change is recorded once, and the changes that change nothing are not recorded
+	c.expect(http.StatusNoContent, owner, http.MethodPut, base+"/name", map[string]string{"name": "Renamed"})
+	c.expect(http.StatusNoContent, owner, http.MethodPut, base+"/name", map[string]string{"name": "Renamed"})
+	c.expect(http.StatusNoContent, owner, http.MethodPost, base+"/members", map[string]string{"memberId": "other"})
+	c.expect(http.StatusNoContent, owner, http.MethodPost, base+"/members", map[string]string{"memberId": "other"})
+	if w := c.send(owner, http.MethodPut, base+"/photo", "image/png", []byte("\x89PNG\r\n\x1a\n")); w.Code != http.StatusNoContent {
+		t.Fatalf("setting the photo: got status %d", w.Code)
+	}
+	c.expect(http.StatusNoContent, member, http.MethodPost, base+"/leave", nil)
+
+	got := records()[before:]
+	want := []string{models.SystemGroupRenamed, models.SystemMemberAdded, models.SystemGroupPhotoChanged, models.SystemMemberLeft}
+	if !slices.Equal(got, want) {
+		t.Errorf("got records %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+)
+
+// maxIdempotencyKeyLength is the maximum length of the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// idempotencyCleanupInterval is how often the expired idempotency keys of all users are deleted
+const idempotencyCleanupInterval = time.Hour
+
+// checkIdempotencyKey reads the Idempotency-Key header of a request that sends a message. If the user has already
+// sent a message with the same key (within the retention window), it replies with the response of that request and
+// returns done. It also replies with 400 if the key is invalid. Otherwise, it returns the key (if any) to be stored
+// with the new message.
+func (rt *_router) checkIdempotencyKey(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) (key string, done bool) {
+	key = r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return "", false
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return "", true
+	}
+
+	// Keys are forgotten after the retention window: this one is deleted now if it expired, the others from time to
+	// time
+	before := time.Now().Add(-rt.idempotencyKeyLifetime)
+	err := rt.db.DeleteIdempotencyKey(ctx.Username, key, before)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error deleting expired idempotency key from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", true
+	}
+	rt.cleanIdempotencyKeys(ctx, before)
+
+	return key, rt.replayIdempotentRequest(w, ctx, ctx.Username, key)
+}
+
+// cleanIdempotencyKeys deletes the idempotency keys used before the given time, if they were last deleted more than
+// idempotencyCleanupInterval ago. Errors are only logged, as the next cleanup deletes the keys anyway.
+func (rt *_router) cleanIdempotencyKeys(ctx reqcontext.RequestContext, before time.Time) {
+	rt.idempotencyMu.Lock()
+	if time.Since(rt.idempotencyCleanedAt) < idempotencyCleanupInterval {
+		rt.idempotencyMu.Unlock()
+		return
+	}
+	rt.idempotencyCleanedAt = time.Now()
+	rt.idempotencyMu.Unlock()
+
+	if err := rt.db.DeleteIdempotencyKeys(before); err != nil {
+		ctx.Logger.WithError(err).Error("error deleting expired idempotency keys from db")
+	}
+}
+
+// replayIdempotentRequest replies with the response of the request of username with the given idempotency key, and
+// returns true; if there is no such request, it returns false without replying.
+func (rt *_router) replayIdempotentRequest(w http.ResponseWriter, ctx reqcontext.RequestContext, username string, key string) bool {
+	conversationID, messageID, err := rt.db.GetIdempotencyKey(username, key)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting idempotency key from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	if messageID == "" {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(struct {
+		ConversationID string `json:"conversationId"`
+		MessageID      string `json:"messageId"`
+	}{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+	})
+	return true
+}
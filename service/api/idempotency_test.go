@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendWithKey sends a message as the user of token, with the given Idempotency-Key, and returns the status and the IDs
+// of the response.
+func (c *testClient) sendWithKey(token string, key string, body any) (status int, conversationID string, messageID string) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.t.Errorf("encoding the request body: %v", err)
+		return 0, "", ""
+	}
+	r := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, r)
+
+	var sent struct {
+		ConversationID string `json:"conversationId"`
+		MessageID      string `json:"messageId"`
+	}
+	_ = json.NewDecoder(w.Body).Decode(&sent)
+	return w.Code, sent.ConversationID, sent.MessageID
+}
+
+func TestIdempotentGroupCreation(t *testing.T) {
+	c := newTestClient(t)
+	alice := c.login("alice")
+	c.login("bob")
+	c.login("carol")
+	body := map[string]any{"isGroup": true, "name": "Friends", "participants": []string{"bob", "carol"}, "text": "hi"}
+
+	// Concurrent retries of the request create a single group
+	const retries = 16
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	conversations := make([]string, retries)
+	for i := range retries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			status, conversationID, _ := c.sendWithKey(alice, "key", body)
+			if status != http.StatusCreated {
+				t.Errorf("got status %d", status)
+			}
+			conversations[i] = conversationID
+		}()
+	}
+	close(start)
+	wg.Wait()
+	for _, id := range conversations[1:] {
+		if id != conversations[0] {
+			t.Fatalf("got conversations %v, want a single one", conversations)
+		}
+	}
+
+	var list []struct {
+		ID string `json:"id"`
+	}
+	decode(t, c.expect(http.StatusOK, alice, http.MethodGet, "/conversations", nil), &list)
+	if len(list) != 1 {
+		t.Errorf("alice has %d conversations, want 1", len(list))
+	}
+}
+
+func TestIdempotencyKeyExpiry(t *testing.T) {
+	c := newTestClient(t)
+	alice := c.login("alice")
+	c.login("bob")
+	body := map[string]any{"recipient": "bob", "text": "hi"}
+
+	_, _, first := c.sendWithKey(alice, "key", body)
+	if _, _, replayed := c.sendWithKey(alice, "key", body); replayed != first {
+		t.Errorf("got message %s on retry, want %s", replayed, first)
+	}
+
+	// Once the key has expired, it sends a new message
+	c.router.idempotencyKeyLifetime = -time.Minute
+	if _, _, resent := c.sendWithKey(alice, "key", body); resent == first || resent == "" {
+		t.Errorf("got message %q after the key expired, want a new one", resent)
+	}
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Outcomes of POST /invites/:token/join
+const (
+	joinStatusJoined  = "joined"
+	joinStatusPending = "pending"
+)
+
+// createGroupInvite handles POST /groups/:groupId/invites. The members who can add members can also share invites.
+// The token of the invite is returned only here.
+func (rt *_router) createGroupInvite(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Group
+	group, ok := rt.managedGroup(w, ps.ByName("groupId"), ctx)
+	if !ok {
+		return
+	}
+
+	// 3. Parse Body. It is optional: by default the invite never expires and has no usage limit.
+	var body struct {
+		ExpiresAt        time.Time `json:"expiresAt"`
+		MaxUses          int       `json:"maxUses"`
+		RequiresApproval bool      `json:"requiresApproval"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if body.MaxUses < 0 || (!body.ExpiresAt.IsZero() && !body.ExpiresAt.After(now)) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 4. Create Invite
+	inviteID, err := uuid.NewV4()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error creating uuid")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	token, err := uuid.NewV4()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error creating uuid")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	invite := models.GroupInvite{
+		ID:               inviteID.String(),
+		Token:            token.String(),
+		GroupID:          group.ID,
+		CreatedBy:        username,
+		CreatedAt:        now,
+		ExpiresAt:        body.ExpiresAt,
+		MaxUses:          body.MaxUses,
+		RequiresApproval: body.RequiresApproval,
+	}
+	err = rt.db.CreateGroupInvite(&invite)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error creating group invite in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(invite)
+}
+
+// getGroupInvites handles GET /groups/:groupId/invites. Expired and used up invites are not listed.
+func (rt *_router) getGroupInvites(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	// 2. Get Group
+	group, ok := rt.managedGroup(w, ps.ByName("groupId"), ctx)
+	if !ok {
+		return
+	}
+
+	// 3. Get Invites
+	invites, err := rt.db.GetGroupInvites(group.ID, time.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting group invites from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(invites)
+}
+
+// revokeGroupInvite handles DELETE /groups/:groupId/invites/:inviteId
+func (rt *_router) revokeGroupInvite(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	// 2. Get Group
+	group, ok := rt.managedGroup(w, ps.ByName("groupId"), ctx)
+	if !ok {
+		return
+	}
+
+	// 3. Revoke Invite
+	revoked, err := rt.db.DeleteGroupInvite(group.ID, ps.ByName("inviteId"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error deleting group invite from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// joinGroup handles POST /invites/:token/join. If the invite requires approval, the user is added only once a member
+// who can add members approves the request.
+func (rt *_router) joinGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Invite
+	invite, err := rt.db.GetGroupInviteByToken(ps.ByName("token"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting group invite from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if invite == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	group, err := rt.db.GetConversation(invite.GroupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if group == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Members do not use up the invite
+	response := struct {
+		GroupID string `json:"groupId"`
+		Status  string `json:"status"`
+	}{GroupID: group.ID, Status: joinStatusJoined}
+	if slices.Contains(group.Participants, username) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// 4. Banned users cannot join, not even through an invite
+	banned, err := rt.db.IsBannedFromGroup(group.ID, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error checking group ban in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if banned {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// 5. Ask to join, or join. Both use the invite, which fails if it expired or ran out of uses in the meantime;
+	// asking again while the first request is pending does not use it.
+	now := time.Now()
+	if invite.RequiresApproval {
+		created, err := rt.db.CreateJoinRequest(group.ID, models.JoinRequest{Username: username, InviteID: invite.ID, CreatedAt: now})
+		if errors.Is(err, database.ErrInviteUnavailable) {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error creating join request in db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if created {
+			var managers []string
+			for _, p := range group.Participants {
+				if hasRole(group, p, group.Permissions.AddMembers) {
+					managers = append(managers, p)
+				}
+			}
+			rt.notify(ctx, eventJoinRequested, eventData{ConversationID: group.ID, Actor: username, Member: username}, managers)
+		}
+
+		response.Status = joinStatusPending
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+	used, err := rt.db.UseGroupInvite(invite.ID, now)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error using group invite in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !used {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	err = rt.admitToGroup(ctx, group, username, username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error adding participant to group in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// getJoinRequests handles GET /groups/:groupId/join-requests
+func (rt *_router) getJoinRequests(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	// 2. Get Group
+	group, ok := rt.managedGroup(w, ps.ByName("groupId"), ctx)
+	if !ok {
+		return
+	}
+
+	// 3. Get Requests
+	requests, err := rt.db.GetJoinRequests(group.ID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting join requests from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(requests)
+}
+
+// approveJoinRequest handles POST /groups/:groupId/join-requests/:username
+func (rt *_router) approveJoinRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Group
+	group, ok := rt.managedGroup(w, ps.ByName("groupId"), ctx)
+	if !ok {
+		return
+	}
+
+	// 3. Users banned after asking to join must be unbanned first
+	member := ps.ByName("username")
+	banned, err := rt.db.IsBannedFromGroup(group.ID, member)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error checking group ban in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if banned {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// 4. Remove Request
+	found, err := rt.db.DeleteJoinRequest(group.ID, member)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error deleting join request from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 5. Add Member
+	if !slices.Contains(group.Participants, member) {
+		err = rt.admitToGroup(ctx, group, username, member)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error adding participant to group in db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejectJoinRequest handles DELETE /groups/:groupId/join-requests/:username
+func (rt *_router) rejectJoinRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	// 2. Get Group
+	group, ok := rt.managedGroup(w, ps.ByName("groupId"), ctx)
+	if !ok {
+		return
+	}
+
+	// 3. Remove Request
+	found, err := rt.db.DeleteJoinRequest(group.ID, ps.ByName("username"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error deleting join request from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// managedGroup returns the group with the given ID if the user of the request can add members to it, as invites and
+// join requests are managed by those members. Otherwise, it writes the error response and returns false.
+func (rt *_router) managedGroup(w http.ResponseWriter, groupID string, ctx reqcontext.RequestContext) (*models.Conversation, bool) {
+	group, err := rt.db.GetConversation(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	if group == nil || !group.IsGroup {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	if !slices.Contains(group.Participants, ctx.Username) || !hasRole(group, ctx.Username, group.Permissions.AddMembers) {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, false
+	}
+	return group, true
+}
+
+// admitToGroup adds member to the group through an invite, notifying the members. The actor is the member who
+// approved the request, or the new member itself if the invite did not require approval. Nothing is recorded if member
+// is already in the group.
+func (rt *_router) admitToGroup(ctx reqcontext.RequestContext, group *models.Conversation, actor string, member string) error {
+	joined := *group
+	joined.Participants = append(slices.Clone(group.Participants), member)
+	system := models.SystemEvent{Kind: models.SystemMemberJoined}
+	if actor != member {
+		system = models.SystemEvent{Kind: models.SystemMemberAdded, Target: member}
+	}
+	record, err := rt.systemMessage(&joined, actor, system)
+	if err != nil {
+		return err
+	}
+	added, err := rt.db.AddParticipant(group.ID, member, record)
+	if err != nil || !added {
+		return err
+	}
+	*group = joined
+	rt.notify(ctx, eventMemberAdded, eventData{ConversationID: group.ID, Actor: actor, Member: member}, group.Participants)
+	rt.notifyMessage(ctx, group, record)
+	return nil
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aaitayev/wasa-homework"
+)
+
+func TestJoinRequestUsesInviteOnce(t *testing.T) {
+	c := newTestClient(t)
+	owner, alice, bob := c.login("owner"), c.login("alice"), c.login("bob")
+	group := c.createGroup(owner)
+	var invite models.GroupInvite
+	decode(t, c.expect(http.StatusCreated, owner, http.MethodPost, "/groups/"+group+"/invites", map[string]any{"maxUses": 2, "requiresApproval": true}), &invite)
+	join := "/invites/" + invite.Token + "/join"
+
+	// Asking again while the request is pending does not use the invite again
+	c.expect(http.StatusAccepted, alice, http.MethodPost, join, nil)
+	c.expect(http.StatusAccepted, alice, http.MethodPost, join, nil)
+	var invites []models.GroupInvite
+	decode(t, c.expect(http.StatusOK, owner, http.MethodGet, "/groups/"+group+"/invites", nil), &invites)
+	if len(invites) != 1 || invites[0].Uses != 1 {
+		t.Fatalf("got invites %+v, want one used once", invites)
+	}
+
+	// The other use is left to someone else
+	c.expect(http.StatusAccepted, bob, http.MethodPost, join, nil)
+	c.expect(http.StatusGone, c.login("carol"), http.MethodPost, join, nil)
+
+	var requests []models.JoinRequest
+	decode(t, c.expect(http.StatusOK, owner, http.MethodGet, "/groups/"+group+"/join-requests", nil), &requests)
+	if len(requests) != 2 || requests[0].Username != "alice" || requests[1].Username != "bob" {
+		t.Errorf("got join requests %+v", requests)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Delivery status of the messages sent by the user, as shown in the conversation view
+const (
+	messageStatusSent      = "sent"
+	messageStatusDelivered = "delivered"
+	messageStatusRead      = "read"
+)
+
+// markConversationRead handles POST /conversations/{conversationId}/read
+// It marks the messages up to `messageId` (by default, the last message of the conversation) as read by the user.
+func (rt *_router) markConversationRead(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Conversation and check membership
+	conversation, status, err := rt.participantConversation(ps.ByName("conversationId"), username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if conversation == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	// 3. Parse Body (optional)
+	var body struct {
+		MessageID string `json:"messageId"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 4. Resolve the message: it must belong to the conversation
+	messageID := body.MessageID
+	if messageID == "" {
+		last, _, err := rt.db.GetMessagesPage(conversation.ID, username, "", "", 1)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting last message from db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(last) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		messageID = last[0].ID
+	} else {
+		msg, err := rt.db.GetMessage(messageID)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting message from db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if msg == nil || msg.ConversationID != conversation.ID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 5. Move the read watermark
+	err = rt.db.MarkConversationRead(conversation.ID, username, messageID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error marking conversation as read in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.notify(ctx, eventConversationRead, eventData{ConversationID: conversation.ID, Actor: username, MessageID: messageID}, conversation.Participants)
+
+	// 6. Response
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setMessageStatuses sets the delivery status of the messages sent by username: "read" once all the other
+// participants have read them, "delivered" once all have received them, "sent" otherwise.
+func (rt *_router) setMessageStatuses(messages []models.Message, username string) error {
+	var ids []string
+	for _, m := range messages {
+		if m.Sender == username && m.System == nil {
+			ids = append(ids, m.ID)
+		}
+	}
+	receipts, err := rt.db.GetMessageReceipts(ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		r, ok := receipts[messages[i].ID]
+		if !ok {
+			continue
+		}
+		switch {
+		case r.Recipients > 0 && r.Read == r.Recipients:
+			messages[i].Status = messageStatusRead
+		case r.Recipients > 0 && r.Delivered == r.Recipients:
+			messages[i].Status = messageStatusDelivered
+		default:
+			messages[i].Status = messageStatusSent
+		}
+	}
+	return nil
+}
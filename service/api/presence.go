@@ -0,0 +1,77 @@
+package api
+
+import (
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+)
+
+// presenceOnlineWindow is how long after their last request users without an open event stream or WebSocket are
+// still shown as online
+const presenceOnlineWindow = 2 * time.Minute
+
+// presenceSaveInterval is how often the last-seen time of an active user is saved. It is shorter than
+// presenceOnlineWindow, so that active users are not shown as offline in between.
+const presenceSaveInterval = 30 * time.Second
+
+// markSeen records the activity of the authenticated user. To spare a write on every request, the last-seen time is only
+// saved if the saved one is older than presenceSaveInterval.
+func (rt *_router) markSeen(ctx reqcontext.RequestContext) {
+	rt.seenMu.Lock()
+	saved := rt.seen[ctx.UserID]
+	rt.seenMu.Unlock()
+	if time.Since(saved) < presenceSaveInterval {
+		return
+	}
+	rt.saveLastSeen(ctx)
+}
+
+// saveLastSeen saves the current time as the last-seen time of the authenticated user. It is used directly when a
+// client disconnects, so that the user is seen offline from then on. Errors are only logged, as the request does not
+// depend on it.
+func (rt *_router) saveLastSeen(ctx reqcontext.RequestContext) {
+	now := time.Now()
+	rt.seenMu.Lock()
+	rt.seen[ctx.UserID] = now
+	rt.seenMu.Unlock()
+
+	if err := rt.db.SetUserLastSeen(ctx.UserID, now); err != nil {
+		ctx.Logger.WithError(err).Error("error saving last seen time in db")
+	}
+}
+
+// isOnline tells whether the user with the given ID, last seen at lastSeen, is online: either connected to the events stream or the
+// WebSocket, or active within presenceOnlineWindow.
+func (rt *_router) isOnline(userID string, lastSeen time.Time) bool {
+	return rt.events.connected(userID) || time.Since(lastSeen) < presenceOnlineWindow
+}
+
+// getPresence returns the presence of the given users, leaving out those who hide it.
+func (rt *_router) getPresence(usernames []string) (map[string]models.Presence, error) {
+	lastSeen, err := rt.db.GetLastSeen(usernames)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := rt.db.GetUserIDs(usernames)
+	if err != nil {
+		return nil, err
+	}
+	presence := make(map[string]models.Presence, len(lastSeen))
+	for username, t := range lastSeen {
+		presence[username] = models.Presence{Online: rt.isOnline(ids[username], t), LastSeen: t}
+	}
+	return presence, nil
+}
+
+// setUserPresence completes the presence of user as seen by viewer: it is removed if the user hides it from others.
+func (rt *_router) setUserPresence(user *models.User, viewer string) {
+	if user.Presence == nil {
+		return
+	}
+	if user.HidePresence && user.Name != viewer {
+		user.Presence = nil
+		return
+	}
+	user.Presence.Online = rt.isOnline(user.ID, user.Presence.LastSeen)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Maximum lengths, in characters, of the profile fields
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 256
+)
+
+// myProfile is the profile of the authenticated user. Unlike the profiles of the other users, it includes their
+// settings.
+type myProfile struct {
+	*models.User
+	HidePresence bool `json:"hidePresence"`
+}
+
+// getMyProfile handles GET /me
+func (rt *_router) getMyProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	// 2. Get Profile
+	rt.writeProfile(w, ctx.Username, true, ctx)
+}
+
+// updateMyProfile handles PATCH /me. Only the fields present in the body are changed; the name is changed with
+// PUT /me/name, and the photo with PUT /me/photo. hidePresence hides whether the user is online, and when they were
+// last seen, from the other users.
+func (rt *_router) updateMyProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Parse Body
+	var body struct {
+		DisplayName  *string `json:"displayName"`
+		Bio          *string `json:"bio"`
+		HidePresence *bool   `json:"hidePresence"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 3. Merge with the current profile
+	user, err := rt.db.GetUserByName(username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting user from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if body.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*body.DisplayName)
+	}
+	if body.Bio != nil {
+		user.Bio = strings.TrimSpace(*body.Bio)
+	}
+	if body.HidePresence != nil {
+		user.HidePresence = *body.HidePresence
+	}
+	if utf8.RuneCountInString(user.DisplayName) > maxDisplayNameLength || utf8.RuneCountInString(user.Bio) > maxBioLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 4. Update Profile
+	err = rt.db.UpdateUserProfile(username, user.DisplayName, user.Bio, user.HidePresence)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error updating user profile in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	rt.setUserPresence(user, username)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(myProfile{User: user, HidePresence: user.HidePresence})
+}
+
+// getUserProfile handles GET /users/:username. The avatar is served by GET /users/:username/photo.
+func (rt *_router) getUserProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	// 2. Get Profile
+	rt.writeProfile(w, ps.ByName("username"), false, ctx)
+}
+
+// writeProfile writes the profile of the user with the given name as seen by the authenticated user, or 404 if there is
+// no such user. If own is set, the user is the authenticated one, and their settings are included (see myProfile).
+func (rt *_router) writeProfile(w http.ResponseWriter, username string, own bool, ctx reqcontext.RequestContext) {
+	user, err := rt.db.GetUserByName(username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting user from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rt.setUserPresence(user, ctx.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	if own {
+		_ = json.NewEncoder(w).Encode(myProfile{User: user, HidePresence: user.HidePresence})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/http"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxReactionLength is the maximum length, in bytes, of a reaction. Emoji made of several code points (flags, skin
+// tones, families) are well below it.
+const maxReactionLength = 32
+
+// addReaction handles PUT /messages/{messageId}/reactions/{emoji}
+func (rt *_router) addReaction(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get the message, checking participation
+	msg, conversation, ok := rt.reactionTarget(w, ps, ctx)
+	if !ok {
+		return
+	}
+
+	// 3. Validate the reaction
+	emoji := ps.ByName("emoji")
+	if !validReaction(emoji) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 4. Save the reaction
+	err := rt.db.AddReaction(msg.ID, username, emoji, time.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error adding reaction in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.notify(ctx, eventReactionAdded, eventData{ConversationID: msg.ConversationID, Actor: username, MessageID: msg.ID, Emoji: emoji}, messageRecipients(conversation, msg))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeReaction handles DELETE /messages/{messageId}/reactions/{emoji}
+// Users can only remove their own reactions.
+func (rt *_router) removeReaction(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get the message, checking participation
+	msg, conversation, ok := rt.reactionTarget(w, ps, ctx)
+	if !ok {
+		return
+	}
+
+	// 3. Remove the reaction
+	emoji := ps.ByName("emoji")
+	removed, err := rt.db.RemoveReaction(msg.ID, username, emoji)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error removing reaction in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if removed {
+		rt.notify(ctx, eventReactionRemoved, eventData{ConversationID: msg.ConversationID, Actor: username, MessageID: msg.ID, Emoji: emoji}, messageRecipients(conversation, msg))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reactionTarget loads the message of a reaction request and its conversation. If the message does not exist, the
+// user is not a participant of its conversation or the message is deleted, it replies with the error and returns
+// false.
+func (rt *_router) reactionTarget(w http.ResponseWriter, ps httprouter.Params, ctx reqcontext.RequestContext) (*models.Message, *models.Conversation, bool) {
+	msg, err := rt.db.GetMessage(ps.ByName("messageId"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting message from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if msg == nil || msg.WithheldFrom == ctx.Username {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	conversation, status, err := rt.participantConversation(msg.ConversationID, ctx.Username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if conversation == nil {
+		w.WriteHeader(status)
+		return nil, nil, false
+	}
+
+	if msg.Deleted || msg.System != nil {
+		w.WriteHeader(http.StatusConflict) // 409 Conflict for soft-deleted and system messages
+		return nil, nil, false
+	}
+	return msg, conversation, true
+}
+
+// validReaction checks that a reaction is a short symbol: no letters, spaces nor control characters. Digits are
+// allowed for keycap emoji.
+func validReaction(emoji string) bool {
+	if emoji == "" || len(emoji) > maxReactionLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	for _, c := range emoji {
+		if unicode.IsLetter(c) || unicode.IsSpace(c) || unicode.IsControl(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// setReactions sets the aggregated reactions of the messages
+func (rt *_router) setReactions(messages []models.Message) error {
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	reactions, err := rt.db.GetReactions(ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// removeFromGroup handles DELETE /groups/:groupId/members/:username. Admins can remove the members with a lower role;
+// with ?ban=true, the removed user cannot join the group again until unbanned.
+func (rt *_router) removeFromGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Group
+	groupID := ps.ByName("groupId")
+	group, err := rt.db.GetConversation(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if group == nil || !group.IsGroup {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Parse Query
+	ban := false
+	if v := r.URL.Query().Get("ban"); v != "" {
+		ban, err = strconv.ParseBool(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 4. Check Permission. Users leave groups with leaveGroup, not by removing themselves.
+	if !hasRole(group, username, models.RoleAdmin) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	member := ps.ByName("username")
+	if !slices.Contains(group.Participants, member) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if member == username {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if roleRank(group.Roles[member]) >= roleRank(group.Roles[username]) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// 5. Remove Member, recording the removal in the conversation for the remaining members
+	var groupBan *models.GroupBan
+	if ban {
+		groupBan = &models.GroupBan{Username: member, BannedBy: username, CreatedAt: time.Now()}
+	}
+	remaining := *group
+	remaining.Participants = slices.DeleteFunc(slices.Clone(group.Participants), func(p string) bool { return p == member })
+	record, err := rt.systemMessage(&remaining, username, models.SystemEvent{Kind: models.SystemMemberRemoved, Target: member})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error preparing system message")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = rt.db.RemoveFromGroup(groupID, member, groupBan, record)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error removing participant from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.notify(ctx, eventMemberRemoved, eventData{ConversationID: groupID, Actor: username, Member: member}, group.Participants)
+	rt.notifyMessage(ctx, &remaining, record)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getGroupBans handles GET /groups/:groupId/bans. Only admins can see the bans.
+func (rt *_router) getGroupBans(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Group
+	groupID := ps.ByName("groupId")
+	group, err := rt.db.GetConversation(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if group == nil || !group.IsGroup {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Check Permission
+	if !hasRole(group, username, models.RoleAdmin) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// 4. Get Bans
+	bans, err := rt.db.GetGroupBans(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting group bans from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(bans)
+}
+
+// unbanFromGroup handles DELETE /groups/:groupId/bans/:username. Only admins can lift the bans.
+func (rt *_router) unbanFromGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Get Group
+	groupID := ps.ByName("groupId")
+	group, err := rt.db.GetConversation(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting conversation from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if group == nil || !group.IsGroup {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// 3. Check Permission
+	if !hasRole(group, username, models.RoleAdmin) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// 4. Lift Ban
+	unbanned, err := rt.db.UnbanFromGroup(groupID, ps.ByName("username"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error unbanning user in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !unbanned {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
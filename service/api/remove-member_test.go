@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/aaitayev/wasa-homework"
+)
+
+func TestRemoveFromGroup(t *testing.T) {
+	c := newTestClient(t)
+	owner, admin, member := c.login("owner"), c.login("admin"), c.login("member")
+	c.login("other")
+	group := c.createGroup(owner, "admin", "member", "other")
+	base := "/groups/" + group
+	c.expect(http.StatusNoContent, owner, http.MethodPut, base+"/members/admin/role", map[string]string{"role": models.RoleAdmin})
+
+	// Members cannot remove anyone, and admins cannot remove the members with their role or a higher one
+	c.expect(http.StatusForbidden, member, http.MethodDelete, base+"/members/other", nil)
+	c.expect(http.StatusForbidden, admin, http.MethodDelete, base+"/members/owner", nil)
+	c.expect(http.StatusBadRequest, admin, http.MethodDelete, base+"/members/admin", nil)
+	c.expect(http.StatusNotFound, admin, http.MethodDelete, base+"/members/nobody", nil)
+
+	// A removal without a ban lets the user be added again
+	c.expect(http.StatusNoContent, admin, http.MethodDelete, base+"/members/other", nil)
+	c.expect(http.StatusNoContent, admin, http.MethodPost, base+"/members", map[string]string{"memberId": "other"})
+
+	// The removal is recorded in the conversation
+	var conversation models.Conversation
+	decode(t, c.expect(http.StatusOK, owner, http.MethodGet, "/conversations/"+group, nil), &conversation)
+	removed := slices.ContainsFunc(conversation.Messages, func(m models.Message) bool {
+		return m.System != nil && m.System.Kind == models.SystemMemberRemoved && m.System.Target == "other" && m.Sender == "admin"
+	})
+	if !removed {
+		t.Error("the removal was not recorded in the conversation")
+	}
+}
+
+func TestBanFromGroup(t *testing.T) {
+	c := newTestClient(t)
+	owner, member := c.login("owner"), c.login("member")
+	group := c.createGroup(owner, "member")
+	base := "/groups/" + group
+
+	// A banned user loses access to the group, and cannot be added again
+	c.expect(http.StatusNoContent, owner, http.MethodDelete, base+"/members/member?ban=true", nil)
+	c.expect(http.StatusForbidden, member, http.MethodGet, base, nil)
+	c.expect(http.StatusConflict, owner, http.MethodPost, base+"/members", map[string]string{"memberId": "member"})
+
+	// Only admins see and lift the bans
+	var bans []models.GroupBan
+	decode(t, c.expect(http.StatusOK, owner, http.MethodGet, base+"/bans", nil), &bans)
+	if len(bans) != 1 || bans[0].Username != "member" || bans[0].BannedBy != "owner" {
+		t.Errorf("got bans %+v", bans)
+	}
+	c.expect(http.StatusForbidden, member, http.MethodDelete, base+"/bans/member", nil)
+	c.expect(http.StatusNoContent, owner, http.MethodDelete, base+"/bans/member", nil)
+	c.expect(http.StatusNotFound, owner, http.MethodDelete, base+"/bans/member", nil)
+
+	// Once unbanned, the user can be added again
+	c.expect(http.StatusNoContent, owner, http.MethodPost, base+"/members", map[string]string{"memberId": "member"})
+	c.expect(http.StatusOK, member, http.MethodGet, base, nil)
+}
@@ -18,4 +18,15 @@ type RequestContext struct {
 
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
+
+	// Username is the authenticated user. It is set only for handlers registered with the authenticated wrapper
+	// (see wrapAuth in the parent package), and it is empty otherwise.
+	Username string
+
+	// UserID is the immutable ID of the authenticated user, which, unlike Username, does not change when the user is
+	// renamed (empty when Username is empty)
+	UserID string
+
+	// SessionID is the ID of the session used to authenticate the request (empty when Username is empty)
+	SessionID string
 }
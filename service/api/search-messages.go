@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// defaultSearchPageSize is the number of results returned by searchMessages when no limit is given
+	defaultSearchPageSize = 20
+
+	// maxSearchPageSize is the maximum limit accepted by searchMessages
+	maxSearchPageSize = 100
+)
+
+// searchMessages handles GET /messages/search (see getMessage)
+// It searches the words of `q` in the messages of the conversations of the user, newest first. The results can be
+// filtered by `sender`, `conversationId` and creation time (`from` inclusive, `to` exclusive, RFC 3339), and are
+// paginated with `limit` and `offset`.
+func (rt *_router) searchMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	username := ctx.Username
+
+	// 2. Read the parameters
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	filter := database.SearchFilter{
+		Sender:         query.Get("sender"),
+		ConversationID: query.Get("conversationId"),
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		filter.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		filter.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultSearchPageSize
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxSearchPageSize {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	offset := 0
+	if o := query.Get("offset"); o != "" {
+		offset, err = strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 3. The conversation filter must be a conversation of the user
+	if filter.ConversationID != "" {
+		conversation, status, err := rt.participantConversation(filter.ConversationID, username)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting conversation from db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if conversation == nil {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	// 4. Search
+	results, err := rt.db.SearchMessages(username, q, filter, limit, offset)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error searching messages in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// 5. Response
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
@@ -3,7 +3,7 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
+	"slices"
 
 	"github.com/aaitayev/wasa-homework"
 	"github.com/julienschmidt/httprouter"
@@ -12,23 +12,8 @@ import (
 
 // searchUsers handles GET /users endpoint
 func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	callingUser, err := rt.db.GetUserByToken(token)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting calling user by token")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if callingUser == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	// 1. Authenticated user (checked by wrapAuth)
+	callingUser := ctx.Username
 
 	// 2. Read query string
 	searchQuery := r.URL.Query().Get("search")
@@ -41,14 +26,22 @@ func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
+	// Users who have blocked the requester are not found
+	blocking, err := rt.db.GetBlockingUsers(callingUser, users)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error checking blocked users in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	// 4. Transform and Filter
 	type UserResponse struct {
 		Name string `json:"name"`
 	}
 	results := make([]UserResponse, 0)
 	for _, username := range users {
-		if username == callingUser {
-			continue // Omit the requester
+		if username == callingUser || slices.Contains(blocking, username) {
+			continue // Omit the requester, and the users who have blocked them
 		}
 		results = append(results, UserResponse{Name: username})
 	}
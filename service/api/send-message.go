@@ -2,10 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/aaitayev/wasa-homework"
 	"github.com/aaitayev/wasa-homework"
 	"github.com/aaitayev/wasa-homework"
 	"github.com/gofrs/uuid"
@@ -14,23 +17,7 @@ import (
 
 // sendMessage handles POST /messages
 func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Auth check
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	senderName, err := rt.db.GetUserByToken(token)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if senderName == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	senderName := ctx.Username
 
 	var body struct {
 		ConversationID string   `json:"conversationId"`
@@ -39,18 +26,45 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		Recipient      string   `json:"recipient"`
 		Name           string   `json:"name"`
 		Participants   []string `json:"participants"`
+		ReplyTo        string   `json:"replyTo"`
+		Attachments    []string `json:"attachments"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if body.Text == "" {
+
+	// Retried requests get the response of the first one
+	idempotencyKey, done := rt.checkIdempotencyKey(w, r, ctx)
+	if done {
+		return
+	}
+
+	// Messages with attachments can have no text
+	if body.Text == "" && len(body.Attachments) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	attachments, status, err := rt.pendingAttachments(body.Attachments, senderName)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting attachments from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	// Replies are only possible in existing conversations
+	if body.ReplyTo != "" && body.ConversationID == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	var conversationID string
 	var conversation *models.Conversation
+	var created bool
 
 	// 3. Handle Conversation Logic
 	if body.ConversationID == "" {
@@ -81,64 +95,115 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 			addParticipant(body.Recipient)
 		}
 		
+		if len(participants) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// The recipients must exist
+		missing, err := rt.db.GetMissingUsers(participants[1:])
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error checking user existence in db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(missing) > 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Users cannot be added to a conversation with someone they have blocked, except a direct one: there, the
+		// messages of the blocked user are withheld
+		if body.IsGroup || len(participants) > 2 {
+			blocking, err := rt.db.GetBlockingUsers(senderName, participants[1:])
+			if err != nil {
+				ctx.Logger.WithError(err).Error("error checking blocked users in db")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if len(blocking) > 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+
 		conversation = &models.Conversation{
 			ID:           conversationID,
 			Participants: participants,
 			Messages:     []models.Message{},
 			IsGroup:      body.IsGroup,
 			Name:         body.Name,
+			CreatedBy:    senderName,
+			CreatedAt:    time.Now(),
 		}
-		err = rt.db.CreateConversation(conversation)
-		if err != nil {
-			ctx.Logger.WithError(err).Error("error creating conversation in db")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		if !body.IsGroup && len(participants) == 2 {
+			// Two users share a single direct conversation
+			conversation, err = rt.db.GetOrCreateDirectConversation(conversation)
+			if err != nil {
+				ctx.Logger.WithError(err).Error("error creating conversation in db")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			conversationID = conversation.ID
+		} else {
+			// The other conversations are created along with the message, see below
+			created = true
 		}
 
 	} else {
 		// Existing conversation
 		conversationID = body.ConversationID
-		conversation, err = rt.db.GetConversation(conversationID)
+		conversation, status, err = rt.participantConversation(conversationID, senderName)
 		if err != nil {
 			ctx.Logger.WithError(err).Error("error getting conversation from db")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		if conversation == nil {
-			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(status)
+			return
+		}
+		if !canPost(conversation, senderName) {
+			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
-		// Check if user is participant
-		isParticipant := false
-		for _, p := range conversation.Participants {
-			if p == senderName {
-				isParticipant = true
-				break
-			}
+		status, err = rt.checkReplyTo(conversation, senderName, body.ReplyTo)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting replied message from db")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
-		if !isParticipant {
-			w.WriteHeader(http.StatusForbidden)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
 			return
 		}
 	}
 
-	// 4. Create Message
-	msgID, err := uuid.NewV4()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+	// 4. Create and save the message
 	msg := models.Message{
-		ID:             msgID.String(),
-		ConversationID: conversationID,
-		SenderID:       senderName,
+		Sender:         senderName,
 		Text:           body.Text,
-		CreatedAt:      time.Now(),
+		ReplyTo:        body.ReplyTo,
+		Attachments:    attachments,
+		IdempotencyKey: idempotencyKey,
+	}
+	if created {
+		err = rt.createConversation(ctx, conversation, &msg)
+	} else {
+		err = rt.postMessage(ctx, conversation, &msg)
+	}
+	if errors.Is(err, database.ErrDuplicateIdempotencyKey) {
+		// A concurrent request with the same key has sent the message
+		if !rt.replayIdempotentRequest(w, ctx, senderName, idempotencyKey) {
+			w.WriteHeader(http.StatusConflict)
+		}
+		return
+	}
+	if errors.Is(err, database.ErrAttachmentUnavailable) {
+		w.WriteHeader(http.StatusConflict)
+		return
 	}
-
-	// 5. Update Conversation
-	err = rt.db.SaveMessage(&msg)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("error saving message in db")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -153,7 +218,126 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		MessageID      string `json:"messageId"`
 	}{
 		ConversationID: conversationID,
-		MessageID:      msgID.String(),
+		MessageID:      msg.ID,
+	})
+}
+
+
+// participantConversation returns the conversation with the given ID if username is one of its participants.
+// Otherwise, it returns nil and the HTTP status to reply with: 404 if the conversation does not exist, 403 if the user
+// is not a participant.
+func (rt *_router) participantConversation(conversationID string, username string) (*models.Conversation, int, error) {
+	conversation, err := rt.db.GetConversation(conversationID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if conversation == nil {
+		return nil, http.StatusNotFound, nil
+	}
+	if !slices.Contains(conversation.Participants, username) {
+		return nil, http.StatusForbidden, nil
+	}
+	return conversation, http.StatusOK, nil
+}
+
+// checkReplyTo checks that a new message of sender in conversation can reply to the message replyTo, if set. It returns
+// the HTTP status to reply with when it cannot: 400 if replyTo is not a message of the conversation (or it is withheld
+// from sender), 409 if it is deleted or a system message.
+func (rt *_router) checkReplyTo(conversation *models.Conversation, sender string, replyTo string) (int, error) {
+	if replyTo == "" {
+		return http.StatusOK, nil
+	}
+	msg, err := rt.db.GetMessage(replyTo)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if msg == nil || msg.ConversationID != conversation.ID || msg.WithheldFrom == sender {
+		return http.StatusBadRequest, nil
+	}
+	if msg.Deleted || msg.System != nil {
+		return http.StatusConflict, nil
+	}
+	return http.StatusOK, nil
+}
+
+// postSystemMessage records in conversation a change made by actor with a system message, and notifies the
+// participants.
+func (rt *_router) postSystemMessage(ctx reqcontext.RequestContext, conversation *models.Conversation, actor string, event models.SystemEvent) error {
+	return rt.postMessage(ctx, conversation, &models.Message{
+		Sender: actor,
+		System: &event,
 	})
 }
 
+// postMessage saves msg as a new message in conversation, and notifies the participants. The caller sets the sender
+// and the content of msg (text, reply, attachments); the rest is set by prepareMessage.
+func (rt *_router) postMessage(ctx reqcontext.RequestContext, conversation *models.Conversation, msg *models.Message) error {
+	err := rt.prepareMessage(conversation, msg)
+	if err != nil {
+		return err
+	}
+
+	err = rt.db.SaveMessage(msg)
+	if err != nil {
+		return err
+	}
+	rt.notifyMessage(ctx, conversation, msg)
+	return nil
+}
+
+// createConversation saves conversation, a new conversation which is not a direct one, along with msg, its first
+// message, and the system message of the creation of a group, in a single transaction: if msg cannot be saved (e.g.,
+// because a concurrent request with the same idempotency key sent it first), no conversation is left behind. The
+// participants are notified.
+func (rt *_router) createConversation(ctx reqcontext.RequestContext, conversation *models.Conversation, msg *models.Message) error {
+	var messages []*models.Message
+	if conversation.IsGroup {
+		record, err := rt.systemMessage(conversation, msg.Sender, models.SystemEvent{Kind: models.SystemGroupCreated, Name: conversation.Name})
+		if err != nil {
+			return err
+		}
+		messages = append(messages, record)
+	}
+	err := rt.prepareMessage(conversation, msg)
+	if err != nil {
+		return err
+	}
+	messages = append(messages, msg)
+
+	err = rt.db.CreateConversation(conversation, messages...)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		rt.notifyMessage(ctx, conversation, m)
+	}
+	return nil
+}
+
+// systemMessage returns a new system message recording in conversation a change made by actor, ready to be saved along
+// with the change. The participants of conversation are those after the change.
+func (rt *_router) systemMessage(conversation *models.Conversation, actor string, event models.SystemEvent) (*models.Message, error) {
+	msg := &models.Message{Sender: actor, System: &event}
+	if err := rt.prepareMessage(conversation, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// notifyMessage notifies the participants of conversation of msg, a new message saved in it.
+func (rt *_router) notifyMessage(ctx reqcontext.RequestContext, conversation *models.Conversation, msg *models.Message) {
+	rt.notify(ctx, eventMessageSent, eventData{ConversationID: conversation.ID, Actor: msg.Sender, Message: msg}, messageRecipients(conversation, msg))
+}
+
+// prepareMessage sets the ID, the conversation and the creation time of msg, a new message in conversation, and
+// withholds it if the recipient of a direct message has blocked the sender.
+func (rt *_router) prepareMessage(conversation *models.Conversation, msg *models.Message) error {
+	msgID, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	msg.ID = msgID.String()
+	msg.ConversationID = conversation.ID
+	msg.CreatedAt = time.Now()
+	return rt.withholdMessage(conversation, msg)
+}
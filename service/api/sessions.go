@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// doLogout handles DELETE /session. It terminates the session used to authenticate the request.
+func (rt *_router) doLogout(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	err := rt.db.DeleteSession(ctx.SessionID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error deleting session from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getMySessions handles GET /me/sessions
+func (rt *_router) getMySessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	sessions, err := rt.db.GetUserSessions(ctx.Username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting user sessions from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	type SessionResponse struct {
+		models.Session
+		Current bool `json:"current"`
+	}
+	results := make([]SessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		results = append(results, SessionResponse{Session: s, Current: s.ID == ctx.SessionID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// revokeMySession handles DELETE /me/sessions/:sessionId
+func (rt *_router) revokeMySession(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	session, err := rt.db.GetSession(ps.ByName("sessionId"))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting session from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Sessions of other users are reported as missing
+	if session == nil || session.UserID != ctx.UserID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = rt.db.DeleteSession(session.ID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error deleting session from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
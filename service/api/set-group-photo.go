@@ -3,8 +3,8 @@ package api
 import (
 	"io"
 	"net/http"
-	"strings"
 
+	"github.com/aaitayev/wasa-homework"
 	"github.com/aaitayev/wasa-homework"
 	"github.com/julienschmidt/httprouter"
 )
@@ -12,25 +12,7 @@ import (
 
 // setGroupPhoto handles the PUT /groups/:groupId/photo endpoint.
 func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// Extract the token
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-
-	// 1. Auth check
-	username, err := rt.db.GetUserByToken(token)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("error getting user by token")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if username == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	username := ctx.Username
 
 	groupID := ps.ByName("groupId")
 	if groupID == "" {
@@ -62,6 +44,10 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	if !hasRole(group, username, group.Permissions.ChangePhoto) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 
 	// 4. Validate Content-Type
 	contentType := r.Header.Get("Content-Type")
@@ -80,13 +66,20 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 		return
 	}
 
-	// 6. Store photo in DB
-	err = rt.db.SetGroupPhoto(groupID, photoBytes, contentType)
+	// 6. Store photo in DB, recording the change in the conversation
+	record, err := rt.systemMessage(group, username, models.SystemEvent{Kind: models.SystemGroupPhotoChanged})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error preparing system message")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = rt.db.SetGroupPhoto(groupID, photoBytes, contentType, record)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("error setting group photo in db")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	rt.notifyMessage(ctx, group, record)
 
 	w.WriteHeader(http.StatusNoContent)
 }
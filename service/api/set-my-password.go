@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/julienschmidt/httprouter"
+)
+
+// setMyPassword handles the PUT /me/password endpoint.
+// Name-only accounts can set a password without further checks (this is how existing users protect their account);
+// for protected accounts the current password is required. The other sessions of the user are revoked.
+func (rt *_router) setMyPassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Parse the request body
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len([]rune(body.NewPassword)) < minPasswordLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 2. Verify the current password, if any
+	user, err := rt.db.GetUserByName(ctx.Username)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error getting user from db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if user.PasswordHash != "" {
+		ok, err := checkPassword(user.PasswordHash, body.CurrentPassword)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error checking password")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	// 3. Store the new password, keeping only the current session
+	hash, err := hashPassword(body.NewPassword)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error hashing password")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = rt.db.SetUserPassword(ctx.Username, hash, ctx.SessionID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error setting user password in db")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,6 +1,26 @@
 package api
 
+import "time"
+
 // Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines.
 func (rt *_router) Close() error {
+	rt.websocketsMu.Lock()
+	rt.closing = true
+	rt.websocketsMu.Unlock()
+
+	// Disconnect the real-time clients, so that their requests end and the HTTP server can shut down
+	rt.events.close()
+
+	// WebSocket connections are not tracked by http.Server.Shutdown: wait for them to send their close frame
+	closed := make(chan struct{})
+	go func() {
+		rt.websockets.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(wsWriteTimeout):
+		rt.baseLogger.Warning("timeout waiting for websocket connections to close")
+	}
 	return nil
 }
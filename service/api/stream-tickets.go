@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// streamTicketLifetime is how long a stream ticket can be used after it is created
+const streamTicketLifetime = 30 * time.Second
+
+// streamTicket authenticates a single connection to GET /events or GET /ws, in place of the Authorization header that
+// the browser EventSource and WebSocket APIs cannot set. It stands for the session token it was created with, which is
+// checked again when the ticket is used (see wrapStream).
+type streamTicket struct {
+	token     string
+	expiresAt time.Time
+}
+
+// createStreamTicket handles POST /session/tickets. It returns a ticket which can be used once, within
+// streamTicketLifetime, in the "ticket" query parameter of GET /events or GET /ws.
+func (rt *_router) createStreamTicket(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// 1. Authenticated user (checked by wrapAuth)
+	token, _ := bearerToken(r)
+
+	// 2. Create the ticket
+	ticket, err := uuid.NewV4()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error creating uuid")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(streamTicketLifetime)
+
+	rt.ticketsMu.Lock()
+	for id, t := range rt.tickets {
+		// Drop the tickets which were never used
+		if now.After(t.expiresAt) {
+			delete(rt.tickets, id)
+		}
+	}
+	rt.tickets[ticket.String()] = streamTicket{token: token, expiresAt: expiresAt}
+	rt.ticketsMu.Unlock()
+
+	// 3. Return the ticket
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(struct {
+		Ticket    string    `json:"ticket"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{
+		Ticket:    ticket.String(),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// redeemStreamTicket returns the session token of a ticket, and removes the ticket. ok is false if the ticket does not
+// exist (or was already used) or has expired.
+func (rt *_router) redeemStreamTicket(ticket string) (token string, ok bool) {
+	rt.ticketsMu.Lock()
+	defer rt.ticketsMu.Unlock()
+	t, ok := rt.tickets[ticket]
+	if !ok {
+		return "", false
+	}
+	delete(rt.tickets, ticket)
+	if time.Now().After(t.expiresAt) {
+		return "", false
+	}
+	return t.token, true
+}
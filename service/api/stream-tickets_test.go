@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamTicket(t *testing.T) {
+	c := newTestClient(t)
+	token := c.login("alice")
+	server := httptest.NewServer(c.handler)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	newTicket := func() string {
+		var ticket struct {
+			Ticket string `json:"ticket"`
+		}
+		decode(t, c.expect(http.StatusCreated, token, http.MethodPost, "/session/tickets", nil), &ticket)
+		return ticket.Ticket
+	}
+	dial := func(ticket string) int {
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL+"?ticket="+ticket, nil)
+		if err == nil {
+			_ = conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("dialing: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	// A ticket opens a WebSocket without the Authorization header, once
+	ticket := newTicket()
+	if status := dial(ticket); status != http.StatusSwitchingProtocols {
+		t.Errorf("got status %d with a new ticket", status)
+	}
+	if status := dial(ticket); status != http.StatusUnauthorized {
+		t.Errorf("got status %d with a used ticket", status)
+	}
+	if status := dial("unknown"); status != http.StatusUnauthorized {
+		t.Errorf("got status %d with an unknown ticket", status)
+	}
+
+	// Tickets are only given to authenticated users, and expire
+	c.expect(http.StatusUnauthorized, "", http.MethodPost, "/session/tickets", nil)
+	expired := newTicket()
+	c.router.tickets[expired] = streamTicket{token: token, expiresAt: time.Now().Add(-time.Second)}
+	c.expect(http.StatusUnauthorized, "", http.MethodGet, "/events?ticket="+expired, nil)
+
+	// Tickets stand for a session, and stop working with it
+	ticket = newTicket()
+	c.expect(http.StatusNoContent, token, http.MethodDelete, "/session", nil)
+	if status := dial(ticket); status != http.StatusUnauthorized {
+		t.Errorf("got status %d with the ticket of a closed session", status)
+	}
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	c := newTestClient(t)
+	token := c.login("alice")
+	server := httptest.NewServer(c.handler)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	header := http.Header{"Authorization": {"Bearer " + token}}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	// The open connection is closed by the server, and no new one is accepted
+	closed := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		closed <- err
+	}()
+	if err := c.router.Close(); err != nil {
+		t.Fatalf("closing the router: %v", err)
+	}
+	select {
+	case err := <-closed:
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("got %v, want a going away close frame", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed")
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("dialing after shutdown: got %v (%v), want status 503", resp, err)
+	}
+}
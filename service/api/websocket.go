@@ -0,0 +1,262 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/aaitayev/wasa-homework"
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// wsPingInterval is how often the server pings WebSocket clients
+	wsPingInterval = 30 * time.Second
+
+	// wsPongTimeout is how long the server waits for any frame (or pong) from a client before closing the connection
+	wsPongTimeout = 2 * wsPingInterval
+
+	// wsWriteTimeout is the deadline for each write on a WebSocket
+	wsWriteTimeout = 10 * time.Second
+
+	// wsMaxMessageSize is the maximum size of a frame sent by the client
+	wsMaxMessageSize = 64 * 1024
+)
+
+var wsUpgrader = websocket.Upgrader{
+	// The API is open to any origin (see the CORS policy), and authentication does not rely on cookies
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsClientMessage is a frame sent by the client. Type is "send" (send Text and Attachments in ConversationID,
+// optionally replying to the message ReplyTo) or "typing" (tell the other participants of ConversationID that the user is typing). RequestID
+// is echoed in the reply.
+type wsClientMessage struct {
+	Type           string   `json:"type"`
+	RequestID      string   `json:"requestId"`
+	ConversationID string   `json:"conversationId"`
+	Text           string   `json:"text"`
+	ReplyTo        string   `json:"replyTo"`
+	Attachments    []string `json:"attachments"`
+}
+
+// wsServerMessage is a frame sent by the server: either an event (like in getEvents), or the reply to a client message
+// ("sent" or "error", with the HTTP status code that the equivalent REST call would have returned).
+type wsServerMessage struct {
+	Type           string          `json:"type"`
+	ID             string          `json:"id,omitempty"`
+	Data           json.RawMessage `json:"data,omitempty"`
+	RequestID      string          `json:"requestId,omitempty"`
+	ConversationID string          `json:"conversationId,omitempty"`
+	MessageID      string          `json:"messageId,omitempty"`
+	Status         int             `json:"status,omitempty"`
+}
+
+// getWebSocket handles GET /ws. It upgrades the connection to a WebSocket which receives the same events as
+// getEvents, and on which the client can send messages and typing indicators. Connections are closed when the
+// server shuts down.
+func (rt *_router) getWebSocket(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// The connection is tracked before the upgrade, so that Close waits for it; none is accepted once Close started
+	rt.websocketsMu.Lock()
+	if rt.closing {
+		rt.websocketsMu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	rt.websockets.Add(1)
+	rt.websocketsMu.Unlock()
+	defer rt.websockets.Done()
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		ctx.Logger.WithError(err).Debug("websocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	sub, _, _ := rt.events.subscribe(ctx.UserID, "")
+	// The user is last seen when they disconnect
+	defer rt.saveLastSeen(ctx)
+	defer rt.events.unsubscribe(sub)
+
+	// replies are written by the writer goroutine, which is the only one writing on the connection
+	replies := make(chan wsServerMessage, subscriberBufferSize)
+	done := make(chan struct{})
+	writerDone := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(writerDone)
+		rt.wsWriter(ctx, conn, sub, replies, done)
+	}()
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		var msg wsClientMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				ctx.Logger.WithError(err).Debug("websocket closed")
+			}
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		reply := rt.handleWebSocketMessage(ctx, msg)
+		if reply == nil {
+			continue
+		}
+		select {
+		case replies <- *reply:
+		case <-writerDone:
+			return
+		}
+	}
+}
+
+// handleWebSocketMessage executes a client message, and returns the reply to send (if any).
+func (rt *_router) handleWebSocketMessage(ctx reqcontext.RequestContext, msg wsClientMessage) *wsServerMessage {
+	fail := func(status int) *wsServerMessage {
+		return &wsServerMessage{Type: "error", RequestID: msg.RequestID, ConversationID: msg.ConversationID, Status: status}
+	}
+
+	switch msg.Type {
+	case "send":
+		if msg.ConversationID == "" || (msg.Text == "" && len(msg.Attachments) == 0) {
+			return fail(http.StatusBadRequest)
+		}
+		attachments, status, err := rt.pendingAttachments(msg.Attachments, ctx.Username)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting attachments from db")
+			return fail(http.StatusInternalServerError)
+		}
+		if status != http.StatusOK {
+			return fail(status)
+		}
+
+		conversation, status, err := rt.participantConversation(msg.ConversationID, ctx.Username)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting conversation from db")
+			return fail(http.StatusInternalServerError)
+		}
+		if conversation == nil {
+			return fail(status)
+		}
+		if !canPost(conversation, ctx.Username) {
+			return fail(http.StatusForbidden)
+		}
+
+		status, err = rt.checkReplyTo(conversation, ctx.Username, msg.ReplyTo)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting replied message from db")
+			return fail(http.StatusInternalServerError)
+		}
+		if status != http.StatusOK {
+			return fail(status)
+		}
+
+		message := models.Message{
+			Sender:      ctx.Username,
+			Text:        msg.Text,
+			ReplyTo:     msg.ReplyTo,
+			Attachments: attachments,
+		}
+		err = rt.postMessage(ctx, conversation, &message)
+		if errors.Is(err, database.ErrAttachmentUnavailable) {
+			return fail(http.StatusConflict)
+		}
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error saving message in db")
+			return fail(http.StatusInternalServerError)
+		}
+		return &wsServerMessage{Type: "sent", RequestID: msg.RequestID, ConversationID: conversation.ID, MessageID: message.ID}
+
+	case "typing":
+		conversation, status, err := rt.participantConversation(msg.ConversationID, ctx.Username)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting conversation from db")
+			return fail(http.StatusInternalServerError)
+		}
+		if conversation == nil {
+			return fail(status)
+		}
+
+		// Typing indicators go to the other participants only, except those who have blocked the user
+		blocking, err := rt.db.GetBlockingUsers(ctx.Username, conversation.Participants)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error checking blocked users in db")
+			return fail(http.StatusInternalServerError)
+		}
+		recipients := make([]string, 0, len(conversation.Participants))
+		for _, p := range conversation.Participants {
+			if p != ctx.Username && !slices.Contains(blocking, p) {
+				recipients = append(recipients, p)
+			}
+		}
+		recipientIDs, err := rt.recipientIDs(recipients)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error getting event recipients from db")
+			return fail(http.StatusInternalServerError)
+		}
+		err = rt.events.signal(eventTyping, eventData{ConversationID: conversation.ID, Actor: ctx.Username}, recipientIDs)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error publishing typing event")
+		}
+		return nil
+
+	default:
+		return fail(http.StatusBadRequest)
+	}
+}
+
+// wsWriter writes events, replies and pings on conn until the reader is done, the subscriber is dropped (e.g., on
+// server shutdown) or a write fails.
+func (rt *_router) wsWriter(ctx reqcontext.RequestContext, conn *websocket.Conn, sub *subscriber, replies <-chan wsServerMessage, done <-chan struct{}) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	writeJSON := func(v any) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(v)
+	}
+
+	for {
+		var err error
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				// Dropped by the hub: say goodbye, the reader will stop when the client closes the connection
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "server closing"),
+					time.Now().Add(wsWriteTimeout))
+				_ = conn.Close()
+				return
+			}
+			out := wsServerMessage{Type: ev.Type, Data: ev.Data}
+			if ev.ID != 0 {
+				out.ID = rt.events.formatID(ev.ID)
+			}
+			err = writeJSON(out)
+		case reply := <-replies:
+			err = writeJSON(reply)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		case <-done:
+			return
+		}
+		if err != nil {
+			ctx.Logger.WithError(err).Debug("error writing on websocket, closing")
+			_ = conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/aaitayev/wasa-homework"
+	"time"
+)
+
+// ErrAttachmentUnavailable is returned by SaveMessage when one of the attachments of the message does not exist or is
+// already attached to another message.
+var ErrAttachmentUnavailable = errors.New("attachment not available")
+
+// attachmentColumns are the columns read by scanAttachment, in order
+var attachmentColumns = "id, " + userNameOf("uploader") + ", message_id, filename, content_type, size, caption, created_at"
+
+// CreateAttachment stores an uploaded file. The attachment is pending until a message of its uploader uses it.
+func (db *appdbimpl) CreateAttachment(att *models.Attachment, data []byte) error {
+	_, err := db.c.Exec(`
+		INSERT INTO attachments (id, uploader, filename, content_type, size, caption, created_at, data)
+		VALUES (?, `+userIDOf+`, ?, ?, ?, ?, ?, ?)
+	`, att.ID, att.UploaderID, att.Filename, att.ContentType, att.Size, att.Caption, att.CreatedAt.Format(time.RFC3339), data)
+	return err
+}
+
+// GetAttachment returns the metadata of an attachment, or nil if it does not exist.
+func (db *appdbimpl) GetAttachment(id string) (*models.Attachment, error) {
+	att, err := scanAttachment(db.c.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return att, nil
+}
+
+// GetAttachmentData returns the content of an attachment, or nil if it does not exist.
+func (db *appdbimpl) GetAttachmentData(id string) ([]byte, error) {
+	var data []byte
+	err := db.c.QueryRow("SELECT data FROM attachments WHERE id = ?", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// GetAttachments returns the attachments of the given messages, in upload order. Messages without attachments are
+// not in the result.
+func (db *appdbimpl) GetAttachments(messageIDs []string) (map[string][]models.Attachment, error) {
+	attachments := make(map[string][]models.Attachment)
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+	ids, err := json.Marshal(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.c.Query("SELECT "+attachmentColumns+` FROM attachments
+		WHERE message_id IN (SELECT value FROM json_each(?))
+		ORDER BY created_at, rowid
+	`, string(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		att, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments[att.MessageID] = append(attachments[att.MessageID], *att)
+	}
+	return attachments, rows.Err()
+}
+
+// CopyAttachments attaches a copy of the attachments sourceIDs to another message (used when forwarding), in a single
+// transaction. The copies get the IDs in copyIDs, in the same order, and keep the upload order of the originals.
+func (db *appdbimpl) CopyAttachments(sourceIDs []string, copyIDs []string, toMessageID string, uploader string) error {
+	if len(sourceIDs) != len(copyIDs) {
+		return errors.New("an attachment copy must have one ID per attachment")
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range sourceIDs {
+		_, err = tx.Exec(`
+			INSERT INTO attachments (id, uploader, message_id, filename, content_type, size, caption, created_at, data)
+			SELECT ?, `+userIDOf+`, ?, filename, content_type, size, caption, created_at, data
+			FROM attachments WHERE id = ?
+		`, copyIDs[i], uploader, toMessageID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeletePendingAttachments deletes the attachments uploaded before the given time and never used in a message.
+func (db *appdbimpl) DeletePendingAttachments(before time.Time) error {
+	_, err := db.c.Exec("DELETE FROM attachments WHERE message_id IS NULL AND created_at < ?", before.Format(time.RFC3339))
+	return err
+}
+
+// scanAttachment scans a row made of attachmentColumns.
+func scanAttachment(row rowScanner) (*models.Attachment, error) {
+	var att models.Attachment
+	var messageID sql.NullString
+	var createdAt string
+	err := row.Scan(&att.ID, &att.UploaderID, &messageID, &att.Filename, &att.ContentType, &att.Size, &att.Caption, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	att.MessageID = messageID.String
+	att.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &att, nil
+}
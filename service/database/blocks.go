@@ -0,0 +1,84 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/aaitayev/wasa-homework"
+	"time"
+)
+
+// BlockUser records that username has blocked the user blocked. Blocking a user again has no effect.
+func (db *appdbimpl) BlockUser(username string, blocked string, createdAt time.Time) error {
+	_, err := db.c.Exec(`
+		INSERT INTO blocks (user_id, blocked_id, created_at) VALUES (`+userIDOf+`, `+userIDOf+`, ?)
+		ON CONFLICT (user_id, blocked_id) DO NOTHING
+	`, username, blocked, createdAt.Format(time.RFC3339))
+	return err
+}
+
+// UnblockUser removes blocked from the users blocked by username. It returns false if the user was not blocked.
+func (db *appdbimpl) UnblockUser(username string, blocked string) (bool, error) {
+	res, err := db.c.Exec("DELETE FROM blocks WHERE user_id = "+userIDOf+" AND blocked_id = "+userIDOf, username, blocked)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// GetBlockedUsers returns the users blocked by username, most recent first.
+func (db *appdbimpl) GetBlockedUsers(username string) ([]models.BlockedUser, error) {
+	rows, err := db.c.Query(`
+		SELECT `+userNameOf("blocked_id")+`, created_at FROM blocks
+		WHERE user_id = `+userIDOf+` ORDER BY created_at DESC, rowid DESC
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := []models.BlockedUser{}
+	for rows.Next() {
+		var b models.BlockedUser
+		var createdAt string
+		if err := rows.Scan(&b.Username, &createdAt); err != nil {
+			return nil, err
+		}
+		b.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		blocked = append(blocked, b)
+	}
+	return blocked, rows.Err()
+}
+
+// HasBlocked tells whether username has blocked other.
+func (db *appdbimpl) HasBlocked(username string, other string) (bool, error) {
+	var blocked bool
+	err := db.c.QueryRow("SELECT EXISTS (SELECT 1 FROM blocks WHERE user_id = "+userIDOf+" AND blocked_id = "+userIDOf+")",
+		username, other).Scan(&blocked)
+	return blocked, err
+}
+
+// GetBlockingUsers returns the names, among the given ones, of the users who have blocked username.
+func (db *appdbimpl) GetBlockingUsers(username string, names []string) ([]string, error) {
+	list, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.c.Query(`
+		SELECT u.name FROM blocks b JOIN users u ON u.id = b.user_id
+		WHERE b.blocked_id = `+userIDOf+` AND u.name IN (SELECT value FROM json_each(?))
+	`, username, string(list))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocking []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		blocking = append(blocking, name)
+	}
+	return blocking, rows.Err()
+}
@@ -2,63 +2,222 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"github.com/aaitayev/wasa-homework"
+	"time"
 )
 
-func (db *appdbimpl) CreateConversation(conv *models.Conversation) error {
+// CreateConversation creates conv and stores its first messages in a single transaction: if one of the messages
+// cannot be saved (see SaveMessage), the conversation is not created either.
+func (db *appdbimpl) CreateConversation(conv *models.Conversation, messages ...*models.Message) error {
 	tx, err := db.c.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("INSERT INTO conversations (id, is_group, name) VALUES (?, ?, ?)", conv.ID, conv.IsGroup, conv.Name)
+	err = insertConversation(tx, conv)
 	if err != nil {
 		return err
 	}
+	for _, msg := range messages {
+		err = insertMessage(tx, msg)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CreateGroup creates a group conversation, with its photo if photo is not nil, in a single transaction.
+func (db *appdbimpl) CreateGroup(group *models.Conversation, photo []byte, photoContentType string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	err = insertConversation(tx, group)
+	if err != nil {
+		return err
+	}
+	if photo != nil {
+		_, err = tx.Exec("INSERT INTO group_photos (group_id, photo, content_type) VALUES (?, ?, ?)", group.ID, photo, photoContentType)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// insertConversation inserts conv and its participants.
+func insertConversation(tx *sql.Tx, conv *models.Conversation) error {
+	_, err := tx.Exec(`
+		INSERT INTO conversations (id, is_group, name, description, created_by, created_at)
+		VALUES (?, ?, ?, ?, `+userIDOf+`, ?)
+	`, conv.ID, conv.IsGroup, conv.Name, conv.Description, conv.CreatedBy, conv.CreatedAt.Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return insertParticipants(tx, conv)
+}
+
+// insertParticipants inserts the participants of conv. The creator of a group is its owner.
+func insertParticipants(tx *sql.Tx, conv *models.Conversation) error {
 	for _, p := range conv.Participants {
-		_, err = tx.Exec("INSERT INTO participants (conversation_id, username) VALUES (?, ?)", conv.ID, p)
+		role := models.RoleMember
+		if conv.IsGroup && p == conv.CreatedBy {
+			role = models.RoleOwner
+		}
+		_, err := tx.Exec("INSERT INTO participants (conversation_id, user_id, role) VALUES (?, "+userIDOf+", ?)", conv.ID, p, role)
 		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// DeleteConversation deletes a conversation with all its messages.
+func (db *appdbimpl) DeleteConversation(id string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	for _, stmt := range []string{
+		"DELETE FROM messages_fts WHERE rowid IN (SELECT rowid FROM messages WHERE conversation_id = ?)",
+		"DELETE FROM reactions WHERE message_id IN (SELECT id FROM messages WHERE conversation_id = ?)",
+		"DELETE FROM message_revisions WHERE message_id IN (SELECT id FROM messages WHERE conversation_id = ?)",
+		"DELETE FROM attachments WHERE message_id IN (SELECT id FROM messages WHERE conversation_id = ?)",
+		"DELETE FROM idempotency_keys WHERE conversation_id = ?",
+		"DELETE FROM messages WHERE conversation_id = ?",
+		"DELETE FROM participants WHERE conversation_id = ?",
+		"DELETE FROM group_photos WHERE group_id = ?",
+		"DELETE FROM group_bans WHERE group_id = ?",
+		"DELETE FROM group_invites WHERE group_id = ?",
+		"DELETE FROM group_join_requests WHERE group_id = ?",
+		"DELETE FROM conversations WHERE id = ?",
+	} {
+		if _, err = tx.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
 	return tx.Commit()
 }
 
+// directKeyOf is the key of the direct conversation between the two users with the given names: the JSON array of
+// their sorted IDs. The unique index on `conversations.direct_key` allows one direct conversation per pair of users.
+const directKeyOf = "(SELECT json_group_array(id) FROM (SELECT id FROM users WHERE name IN (?, ?) ORDER BY id))"
+
+// GetOrCreateDirectConversation returns the direct (non-group) conversation between the two participants of conv, if
+// there is one; otherwise, it creates conv and returns it. There is at most one direct conversation per pair of users.
+func (db *appdbimpl) GetOrCreateDirectConversation(conv *models.Conversation) (*models.Conversation, error) {
+	if conv.IsGroup || len(conv.Participants) != 2 {
+		return nil, errors.New("a direct conversation must have two participants")
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The insert comes first, so that the transaction holds the write lock from the start and two concurrent calls
+	// cannot both miss the existing conversation
+	res, err := tx.Exec(`
+		INSERT INTO conversations (id, is_group, name, description, created_by, created_at, direct_key)
+		VALUES (?, 0, ?, ?, `+userIDOf+`, ?, `+directKeyOf+`)
+		ON CONFLICT (direct_key) DO NOTHING
+	`, conv.ID, conv.Name, conv.Description, conv.CreatedBy, conv.CreatedAt.Format(time.RFC3339),
+		conv.Participants[0], conv.Participants[1])
+	if err != nil {
+		return nil, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if inserted == 0 {
+		// The pair already has a direct conversation
+		var existingID string
+		err = tx.QueryRow("SELECT id FROM conversations WHERE direct_key = "+directKeyOf,
+			conv.Participants[0], conv.Participants[1]).Scan(&existingID)
+		if err != nil {
+			return nil, err
+		}
+		_ = tx.Rollback()
+		return db.GetConversation(existingID)
+	}
+
+	err = insertParticipants(tx, conv)
+	if err != nil {
+		return nil, err
+	}
+	return conv, tx.Commit()
+}
+
 func (db *appdbimpl) GetConversation(id string) (*models.Conversation, error) {
 	var conv models.Conversation
-	err := db.c.QueryRow("SELECT id, is_group, name FROM conversations WHERE id = ?", id).Scan(&conv.ID, &conv.IsGroup, &conv.Name)
+	var createdBy, createdAt sql.NullString
+	err := db.c.QueryRow(`
+		SELECT id, is_group, name, description, (SELECT name FROM users WHERE id = created_by), created_at,
+			perm_rename, perm_change_photo, perm_add_members, perm_post
+		FROM conversations WHERE id = ?
+	`, id).Scan(&conv.ID, &conv.IsGroup, &conv.Name, &conv.Description, &createdBy, &createdAt,
+		&conv.Permissions.Rename, &conv.Permissions.ChangePhoto, &conv.Permissions.AddMembers, &conv.Permissions.Post)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	conv.CreatedBy = createdBy.String
+	conv.CreatedAt, _ = time.Parse(time.RFC3339, createdAt.String)
 
-	// Get participants
-	rows, err := db.c.Query("SELECT username FROM participants WHERE conversation_id = ?", id)
+	// Get participants, in the order they joined
+	rows, err := db.c.Query(`
+		SELECT u.name, p.role FROM participants p JOIN users u ON u.id = p.user_id
+		WHERE p.conversation_id = ? ORDER BY p.rowid
+	`, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	conv.Roles = make(map[string]string)
 	for rows.Next() {
-		var p string
-		if err := rows.Scan(&p); err != nil {
+		var p, role string
+		if err := rows.Scan(&p, &role); err != nil {
 			return nil, err
 		}
 		conv.Participants = append(conv.Participants, p)
+		conv.Roles[p] = role
 	}
 
 	return &conv, rows.Err()
 }
 
-func (db *appdbimpl) UpdateConversationName(id string, name string) error {
-	_, err := db.c.Exec("UPDATE conversations SET name = ? WHERE id = ?", name, id)
-	return err
+// UpdateConversationName renames a conversation and stores record, the system message of the change, in a single
+// transaction.
+func (db *appdbimpl) UpdateConversationName(id string, name string, record *models.Message) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE conversations SET name = ? WHERE id = ?", name, id)
+	if err != nil {
+		return err
+	}
+	err = insertMessage(tx, record)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (db *appdbimpl) GetUserConversations(username string) ([]models.Conversation, error) {
@@ -67,7 +226,7 @@ func (db *appdbimpl) GetUserConversations(username string) ([]models.Conversatio
 		SELECT c.id, c.is_group, c.name 
 		FROM conversations c
 		JOIN participants p ON c.id = p.conversation_id
-		WHERE p.username = ?
+		WHERE p.user_id = `+userIDOf+`
 	`, username)
 	if err != nil {
 		return nil, err
@@ -93,10 +252,11 @@ func (db *appdbimpl) GetUserConversations(username string) ([]models.Conversatio
 
 	// 2. Fetch ALL participants for these conversations in one go
 	pRows, err := db.c.Query(`
-		SELECT p.conversation_id, p.username 
+		SELECT p.conversation_id, u.name 
 		FROM participants p
+		JOIN users u ON u.id = p.user_id
 		WHERE p.conversation_id IN (
-			SELECT conversation_id FROM participants WHERE username = ?
+			SELECT conversation_id FROM participants WHERE user_id = `+userIDOf+`
 		)
 	`, username)
 	if err != nil {
@@ -117,12 +277,198 @@ func (db *appdbimpl) GetUserConversations(username string) ([]models.Conversatio
 	return conversations, pRows.Err()
 }
 
-func (db *appdbimpl) AddParticipant(conversationID string, username string) error {
-	_, err := db.c.Exec("INSERT OR IGNORE INTO participants (conversation_id, username) VALUES (?, ?)", conversationID, username)
-	return err
+// GetConversationSummaries returns a page of the conversations of a user, each with its participants and last message,
+// in a single query. Conversations are ordered by last message, newest first; those without messages come last,
+// newest conversation first. The text of a deleted last message is not returned. UnreadCount counts the messages of
+// the other participants that the user has not read and that are not deleted nor system messages. The messages withheld
+// from the user are ignored, and the conversations where all of them are withheld are left out.
+func (db *appdbimpl) GetConversationSummaries(username string, limit int, offset int) ([]models.ConversationSummary, error) {
+	rows, err := db.c.Query(`
+		SELECT c.id, c.is_group, c.name,
+			(SELECT json_group_array(u.name) FROM participants pp JOIN users u ON u.id = pp.user_id WHERE pp.conversation_id = c.id),
+			m.id, `+userNameOf("m.sender")+`, m.text, m.created_at, m.deleted, m.system_event, `+userNameOf("m.system_target")+`, m.system_name,
+			(SELECT COUNT(*) FROM messages um WHERE um.conversation_id = c.id AND um.sender != p.user_id AND NOT um.deleted
+				AND um.system_event IS NULL AND um.withheld_from IS NOT p.user_id
+				AND (p.read_message_id IS NULL
+					OR (um.created_at, um.rowid) > (SELECT created_at, rowid FROM messages WHERE id = p.read_message_id)))
+		FROM conversations c
+		JOIN participants p ON p.conversation_id = c.id AND p.user_id = `+userIDOf+`
+		LEFT JOIN messages m ON m.rowid = (
+			SELECT lm.rowid FROM messages lm WHERE lm.conversation_id = c.id AND lm.withheld_from IS NOT p.user_id
+			ORDER BY lm.created_at DESC, lm.rowid DESC LIMIT 1
+		)
+		WHERE m.id IS NOT NULL OR NOT EXISTS (SELECT 1 FROM messages wm WHERE wm.conversation_id = c.id)
+		ORDER BY m.created_at IS NULL, m.created_at DESC, m.rowid DESC, c.rowid DESC
+		LIMIT ? OFFSET ?
+	`, username, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make([]models.ConversationSummary, 0, limit)
+	for rows.Next() {
+		var s models.ConversationSummary
+		var name, participants, msgID, sender, text, createdAt sql.NullString
+		var systemEvent, systemTarget, systemName sql.NullString
+		var deleted sql.NullBool
+		err := rows.Scan(&s.ID, &s.IsGroup, &name, &participants, &msgID, &sender, &text, &createdAt, &deleted,
+			&systemEvent, &systemTarget, &systemName, &s.UnreadCount)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Name = name.String
+		if err := json.Unmarshal([]byte(participants.String), &s.Participants); err != nil {
+			return nil, err
+		}
+		if msgID.Valid {
+			s.LastMessageID = msgID.String
+			s.LastMessageSender = sender.String
+			s.LastMessageAt, _ = time.Parse(time.RFC3339, createdAt.String)
+			s.LastMessageDeleted = deleted.Bool
+			if !deleted.Bool {
+				s.LastMessageText = text.String
+			}
+			if systemEvent.Valid {
+				s.LastMessageSystem = &models.SystemEvent{Kind: systemEvent.String, Target: systemTarget.String, Name: systemName.String}
+			}
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// AddParticipant adds username to a conversation and stores record, the system message of the addition, in a single
+// transaction. It returns false, and stores nothing, if the user was already a participant.
+func (db *appdbimpl) AddParticipant(conversationID string, username string, record *models.Message) (bool, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT OR IGNORE INTO participants (conversation_id, user_id) VALUES (?, "+userIDOf+")", conversationID, username)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+	err = insertMessage(tx, record)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// migrateDirectConversations merges the direct conversations between the same pair of users, which older versions
+// created on every new chat, into the oldest one. The messages of the duplicates are moved to it, and each
+// participant keeps the most recent of their read receipts. The remaining direct conversations get their pair key.
+func (db *appdbimpl) migrateDirectConversations() error {
+	rows, err := db.c.Query(`
+		SELECT c.id, (SELECT json_group_array(user_id) FROM (
+			SELECT user_id FROM participants p WHERE p.conversation_id = c.id ORDER BY user_id
+		))
+		FROM conversations c
+		WHERE NOT c.is_group AND (SELECT COUNT(*) FROM participants p WHERE p.conversation_id = c.id) = 2
+		ORDER BY c.rowid
+	`)
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]string)
+	duplicates := make(map[string][]string)
+	for rows.Next() {
+		var id, pair string
+		if err := rows.Scan(&id, &pair); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if keptID, ok := kept[pair]; ok {
+			duplicates[keptID] = append(duplicates[keptID], id)
+		} else {
+			kept[pair] = id
+		}
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for keptID, ids := range duplicates {
+		for _, id := range ids {
+			_, err = tx.Exec(`
+				UPDATE participants SET
+					delivered_message_id = (
+						SELECT m.id FROM participants dp JOIN messages m ON m.id = dp.delivered_message_id
+						WHERE dp.user_id = participants.user_id AND dp.conversation_id IN (?, ?)
+						ORDER BY m.created_at DESC, m.rowid DESC LIMIT 1
+					),
+					read_message_id = (
+						SELECT m.id FROM participants dp JOIN messages m ON m.id = dp.read_message_id
+						WHERE dp.user_id = participants.user_id AND dp.conversation_id IN (?, ?)
+						ORDER BY m.created_at DESC, m.rowid DESC LIMIT 1
+					)
+				WHERE conversation_id = ?
+			`, keptID, id, keptID, id, keptID)
+			if err != nil {
+				return err
+			}
+
+			for _, stmt := range []string{
+				"UPDATE messages SET conversation_id = ? WHERE conversation_id = ?",
+				"UPDATE idempotency_keys SET conversation_id = ? WHERE conversation_id = ?",
+			} {
+				if _, err = tx.Exec(stmt, keptID, id); err != nil {
+					return err
+				}
+			}
+			for _, stmt := range []string{
+				"DELETE FROM participants WHERE conversation_id = ?",
+				"DELETE FROM group_photos WHERE group_id = ?",
+				"DELETE FROM conversations WHERE id = ?",
+			} {
+				if _, err = tx.Exec(stmt, id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Key the remaining direct conversations by their pair of users
+	_, err = tx.Exec(`
+		UPDATE conversations SET direct_key = (SELECT json_group_array(user_id) FROM (
+			SELECT user_id FROM participants p WHERE p.conversation_id = conversations.id ORDER BY user_id
+		))
+		WHERE NOT is_group AND direct_key IS NULL
+			AND (SELECT COUNT(*) FROM participants p WHERE p.conversation_id = conversations.id) = 2
+	`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (db *appdbimpl) RemoveParticipant(conversationID string, username string) error {
-	_, err := db.c.Exec("DELETE FROM participants WHERE conversation_id = ? AND username = ?", conversationID, username)
+// migrateConversationCreators fills the creator and the creation time of the conversations created before they were
+// recorded, with the sender and the time of their first message.
+func (db *appdbimpl) migrateConversationCreators() error {
+	_, err := db.c.Exec(`
+		UPDATE conversations SET
+			created_by = (
+				SELECT m.sender FROM messages m WHERE m.conversation_id = conversations.id
+				ORDER BY m.created_at, m.rowid LIMIT 1
+			),
+			created_at = (SELECT MIN(m.created_at) FROM messages m WHERE m.conversation_id = conversations.id)
+		WHERE created_at IS NULL
+	`)
 	return err
 }
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+)
+
+func TestMigrateDirectConversations(t *testing.T) {
+	db := openTestDB(t, append(baselineSchema,
+		"INSERT INTO users (name, token) VALUES ('alice', 't1'), ('bob', 't2'), ('carol', 't3')",
+		"INSERT INTO conversations (id, is_group, name) VALUES ('first', 0, ''), ('second', 0, ''), ('other', 0, ''), ('group', 1, 'Friends')",
+		"INSERT INTO participants (conversation_id, username) VALUES ('first', 'alice'), ('first', 'bob'), "+
+			"('second', 'bob'), ('second', 'alice'), ('other', 'alice'), ('other', 'carol'), ('group', 'alice'), ('group', 'bob')",
+		"INSERT INTO messages (id, conversation_id, sender, text, created_at) VALUES "+
+			"('m1', 'first', 'alice', 'one', '2024-01-01T10:00:00Z'), ('m2', 'second', 'bob', 'two', '2024-01-01T11:00:00Z'), "+
+			"('m3', 'group', 'alice', 'three', '2024-01-01T12:00:00Z')",
+	)...)
+
+	// The duplicate is merged into the oldest conversation of the pair; the other conversations are kept
+	for id, want := range map[string]bool{"first": true, "second": false, "other": true, "group": true} {
+		conv, err := db.GetConversation(id)
+		if err != nil {
+			t.Fatalf("getting conversation %s: %v", id, err)
+		}
+		if (conv != nil) != want {
+			t.Errorf("conversation %s: exists = %t, want %t", id, conv != nil, want)
+		}
+	}
+	if n := queryString(t, db, "SELECT COUNT(*) FROM messages WHERE conversation_id = 'first'"); n != "2" {
+		t.Errorf("got %s messages in the merged conversation, want 2", n)
+	}
+	if n := queryString(t, db, "SELECT COUNT(*) FROM participants WHERE conversation_id = 'second'"); n != "0" {
+		t.Errorf("the merged conversation still has %s participants", n)
+	}
+
+	// The direct conversations are keyed by their pair of users; groups are not
+	if n := queryString(t, db, "SELECT COUNT(*) FROM conversations WHERE direct_key IS NOT NULL"); n != "2" {
+		t.Errorf("got %s keyed conversations, want 2", n)
+	}
+	conv, err := db.GetOrCreateDirectConversation(&models.Conversation{
+		ID:           "new",
+		Participants: []string{"bob", "alice"},
+		CreatedBy:    "bob",
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("getting the direct conversation: %v", err)
+	}
+	if conv.ID != "first" {
+		t.Errorf("got conversation %s, want first", conv.ID)
+	}
+}
+
+func TestGetOrCreateDirectConversationConcurrently(t *testing.T) {
+	db := openTestDB(t)
+	for _, name := range []string{"alice", "bob"} {
+		if err := db.CreateUser(&models.User{ID: name + "-id", Name: name}); err != nil {
+			t.Fatalf("creating user %s: %v", name, err)
+		}
+	}
+
+	// Concurrent first messages between the same users all end up in the same conversation
+	const attempts = 8
+	ids := make([]string, attempts)
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conv, err := db.GetOrCreateDirectConversation(&models.Conversation{
+				ID:           fmt.Sprintf("conversation-%d", i),
+				Participants: []string{"alice", "bob"},
+				CreatedBy:    "alice",
+				CreatedAt:    time.Now(),
+			})
+			if err == nil {
+				ids[i] = conv.ID
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	for i := range attempts {
+		if errs[i] != nil {
+			t.Fatalf("attempt %d: %v", i, errs[i])
+		}
+		if ids[i] != ids[0] {
+			t.Errorf("attempt %d got conversation %s, attempt 0 got %s", i, ids[i], ids[0])
+		}
+	}
+	if n := queryString(t, db, "SELECT COUNT(*) FROM conversations"); n != "1" {
+		t.Errorf("got %s conversations, want 1", n)
+	}
+	if n := queryString(t, db, "SELECT COUNT(*) FROM participants"); n != "2" {
+		t.Errorf("got %s participants, want 2", n)
+	}
+}
+
+func TestCreateConversationWithUsedIdempotencyKey(t *testing.T) {
+	db := openTestDB(t)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := db.CreateUser(&models.User{ID: name + "-id", Name: name}); err != nil {
+			t.Fatalf("creating user %s: %v", name, err)
+		}
+	}
+	newGroup := func(id string) *models.Conversation {
+		return &models.Conversation{ID: id, IsGroup: true, Name: "Friends", Participants: []string{"alice", "bob", "carol"},
+			CreatedBy: "alice", CreatedAt: time.Now()}
+	}
+	newMessage := func(id string, conversationID string) *models.Message {
+		return &models.Message{ID: id, ConversationID: conversationID, Sender: "alice", Text: "hi", CreatedAt: time.Now(),
+			IdempotencyKey: "key"}
+	}
+
+	if err := db.CreateConversation(newGroup("first"), newMessage("m1", "first")); err != nil {
+		t.Fatalf("creating the first group: %v", err)
+	}
+
+	// A request that lost the race for the key leaves nothing behind
+	err := db.CreateConversation(newGroup("second"), newMessage("m2", "second"))
+	if !errors.Is(err, ErrDuplicateIdempotencyKey) {
+		t.Fatalf("got %v, want ErrDuplicateIdempotencyKey", err)
+	}
+	if conv, err := db.GetConversation("second"); err != nil || conv != nil {
+		t.Errorf("got conversation %+v (%v), want none", conv, err)
+	}
+	if n := queryString(t, db, "SELECT COUNT(*) FROM messages"); n != "1" {
+		t.Errorf("got %s messages, want 1", n)
+	}
+}
@@ -41,33 +41,100 @@ import (
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
 	// User operations
-	CreateUser(name string, token string) error
+	CreateUser(user *models.User) error
 	GetUserByName(name string) (*models.User, error)
-	GetUserByToken(token string) (string, error)
+	UpdateUserProfile(name string, displayName string, bio string, hidePresence bool) error
+	SetUserLastSeen(userID string, at time.Time) error
+	GetLastSeen(names []string) (map[string]time.Time, error)
+	GetUserIDs(names []string) (map[string]string, error)
+	GetMissingUsers(names []string) ([]string, error)
+	SetUserPassword(name string, passwordHash string, keepSession string) error
 	UpdateUserName(oldName string, newName string) error
 	SearchUsers(query string) ([]string, error)
 
+	// Session operations
+	CreateSession(session *models.Session) error
+	GetSessionByToken(token string, idleTimeout time.Duration) (*models.Session, error)
+	GetSession(id string) (*models.Session, error)
+	GetUserSessions(username string) ([]models.Session, error)
+	DeleteSession(id string) error
+
 	// Conversation operations
-	CreateConversation(conv *models.Conversation) error
+	CreateConversation(conv *models.Conversation, messages ...*models.Message) error
+	CreateGroup(group *models.Conversation, photo []byte, photoContentType string) error
+	DeleteConversation(id string) error
 	GetConversation(id string) (*models.Conversation, error)
-	UpdateConversationName(id string, name string) error
+	UpdateConversationName(id string, name string, record *models.Message) error
+	GetOrCreateDirectConversation(conv *models.Conversation) (*models.Conversation, error)
 	GetUserConversations(username string) ([]models.Conversation, error)
+	GetConversationSummaries(username string, limit int, offset int) ([]models.ConversationSummary, error)
+
+	// Read receipt operations
+	MarkConversationsDelivered(username string, conversationIDs []string) error
+	MarkConversationRead(conversationID string, username string, messageID string) error
+	GetMessageReceipts(messageIDs []string) (map[string]models.MessageReceipts, error)
 
 	// Message operations
 	SaveMessage(msg *models.Message) error
 	GetMessage(id string) (*models.Message, error)
-	RemoveParticipant(conversationID string, username string) error
 	DeleteMessage(id string) error
-	GetMessages(conversationID string) ([]models.Message, error)
-	UpdateMessageComment(id string, comment string, commentedAt time.Time) error
+	GetMessagesPage(conversationID string, viewer string, before string, after string, limit int) ([]models.Message, bool, error)
+	EditMessage(id string, text string, editedAt time.Time) error
+	GetMessageRevisions(id string) ([]models.MessageRevision, error)
+	SearchMessages(username string, query string, filter SearchFilter, limit int, offset int) ([]models.SearchResult, error)
+	GetReplies(id string, viewer string) ([]models.Message, error)
+	GetQuotes(messageIDs []string) (map[string]models.Quote, error)
+
+	// Idempotency key operations
+	GetIdempotencyKey(username string, key string) (string, string, error)
+	DeleteIdempotencyKey(username string, key string, before time.Time) error
+	DeleteIdempotencyKeys(before time.Time) error
+
+	// Attachment operations
+	CreateAttachment(att *models.Attachment, data []byte) error
+	GetAttachment(id string) (*models.Attachment, error)
+	GetAttachmentData(id string) ([]byte, error)
+	GetAttachments(messageIDs []string) (map[string][]models.Attachment, error)
+	CopyAttachments(sourceIDs []string, copyIDs []string, toMessageID string, uploader string) error
+	DeletePendingAttachments(before time.Time) error
+
+	// Reaction operations
+	AddReaction(messageID string, username string, emoji string, createdAt time.Time) error
+	RemoveReaction(messageID string, username string, emoji string) (bool, error)
+	GetReactions(messageIDs []string) (map[string][]models.Reaction, error)
 
 	// Participant operations
-	AddParticipant(conversationID string, username string) error
+	AddParticipant(conversationID string, username string, record *models.Message) (bool, error)
+	SetParticipantRole(conversationID string, username string, role string) error
+	TransferOwnership(conversationID string, from string, to string) error
+	LeaveGroup(groupID string, username string, newOwner string, record *models.Message) error
+	SetGroupPermissions(groupID string, permissions models.GroupPermissions) error
+	RemoveFromGroup(groupID string, username string, ban *models.GroupBan, record *models.Message) error
+	UnbanFromGroup(groupID string, username string) (bool, error)
+	IsBannedFromGroup(groupID string, username string) (bool, error)
+	GetGroupBans(groupID string) ([]models.GroupBan, error)
+
+	// Group invite operations
+	CreateGroupInvite(invite *models.GroupInvite) error
+	GetGroupInviteByToken(token string) (*models.GroupInvite, error)
+	GetGroupInvites(groupID string, now time.Time) ([]models.GroupInvite, error)
+	DeleteGroupInvite(groupID string, id string) (bool, error)
+	UseGroupInvite(id string, now time.Time) (bool, error)
+	CreateJoinRequest(groupID string, request models.JoinRequest) (bool, error)
+	GetJoinRequests(groupID string) ([]models.JoinRequest, error)
+	DeleteJoinRequest(groupID string, username string) (bool, error)
+
+	// Block operations
+	BlockUser(username string, blocked string, createdAt time.Time) error
+	UnblockUser(username string, blocked string) (bool, error)
+	GetBlockedUsers(username string) ([]models.BlockedUser, error)
+	HasBlocked(username string, other string) (bool, error)
+	GetBlockingUsers(username string, names []string) ([]string, error)
 
 	// Photo operations
 	SetUserPhoto(username string, photo []byte, contentType string) error
 	GetUserPhoto(username string) ([]byte, string, error)
-	SetGroupPhoto(groupID string, photo []byte, contentType string) error
+	SetGroupPhoto(groupID string, photo []byte, contentType string, record *models.Message) error
 	GetGroupPhoto(groupID string) ([]byte, string, error)
 
 	Ping() error
@@ -75,32 +142,73 @@ type AppDatabase interface {
 
 type appdbimpl struct {
 	c *sql.DB
+
+	// tokenKey is the secret key used to hash session tokens before storing them
+	tokenKey []byte
 }
 
+// sessionsTable is the schema of the sessions table. Tokens are never stored in plaintext, see appdbimpl.hashToken.
+const sessionsTable = `CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	user_id TEXT NOT NULL,
+	device TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	last_used_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);`
+
 // New returns a new instance of AppDatabase based on the SQLite connection `db`.
 // `db` is required - an error will be returned if `db` is `nil`.
-func New(db *sql.DB) (AppDatabase, error) {
+// `tokenKey` is the secret used to hash session tokens; it is required, and it must not change between restarts,
+// otherwise all existing sessions are invalidated.
+func New(db *sql.DB, tokenKey []byte) (AppDatabase, error) {
 	if db == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
+	if len(tokenKey) == 0 {
+		return nil, errors.New("token key is required when building a AppDatabase")
+	}
+	appdb := &appdbimpl{
+		c:        db,
+		tokenKey: tokenKey,
+	}
 
 	// Create tables if they don't exist
 	tables := []string{
 		`CREATE TABLE IF NOT EXISTS users (
-			name TEXT PRIMARY KEY,
-			token TEXT NOT NULL
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			password_hash TEXT,
+			display_name TEXT NOT NULL DEFAULT '',
+			bio TEXT NOT NULL DEFAULT '',
+			created_at DATETIME,
+			last_seen_at DATETIME,
+			hide_presence BOOLEAN NOT NULL DEFAULT 0
 		);`,
+		sessionsTable,
 		`CREATE TABLE IF NOT EXISTS conversations (
 			id TEXT PRIMARY KEY,
 			is_group BOOLEAN NOT NULL DEFAULT 0,
-			name TEXT
+			name TEXT,
+			description TEXT NOT NULL DEFAULT '',
+			created_by TEXT REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			created_at DATETIME,
+			perm_rename TEXT NOT NULL DEFAULT 'admin',
+			perm_change_photo TEXT NOT NULL DEFAULT 'admin',
+			perm_add_members TEXT NOT NULL DEFAULT 'admin',
+			perm_post TEXT NOT NULL DEFAULT 'member'
 		);`,
 		`CREATE TABLE IF NOT EXISTS participants (
 			conversation_id TEXT NOT NULL,
-			username TEXT NOT NULL,
-			PRIMARY KEY (conversation_id, username),
+			user_id TEXT NOT NULL,
+			delivered_message_id TEXT,
+			read_message_id TEXT,
+			role TEXT NOT NULL DEFAULT 'member',
+			PRIMARY KEY (conversation_id, user_id),
 			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
-			FOREIGN KEY (username) REFERENCES users(name) ON DELETE CASCADE ON UPDATE CASCADE
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
 		);`,
 		`CREATE TABLE IF NOT EXISTS messages (
 			id TEXT PRIMARY KEY,
@@ -109,16 +217,94 @@ func New(db *sql.DB) (AppDatabase, error) {
 			text TEXT NOT NULL,
 			created_at DATETIME NOT NULL,
 			deleted BOOLEAN NOT NULL DEFAULT 0,
-			comment TEXT,
-			commented_at DATETIME,
 			forwarded_from TEXT,
+			edited_at DATETIME,
+			reply_to TEXT,
+			system_event TEXT,
+			system_target TEXT,
+			system_name TEXT,
+			withheld_from TEXT,
 			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
 		);`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_conversation_created ON messages (conversation_id, created_at);`,
+		`CREATE TABLE IF NOT EXISTS message_revisions (
+			message_id TEXT NOT NULL,
+			text TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			replaced_at DATETIME NOT NULL,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_message_revisions_message ON message_revisions (message_id, replaced_at);`,
+		`CREATE TABLE IF NOT EXISTS attachments (
+			id TEXT PRIMARY KEY,
+			uploader TEXT NOT NULL,
+			message_id TEXT,
+			filename TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			caption TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			data BLOB NOT NULL,
+			FOREIGN KEY (uploader) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments (message_id);`,
+		`CREATE TABLE IF NOT EXISTS group_bans (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			banned_by TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS group_invites (
+			id TEXT PRIMARY KEY,
+			token_hash TEXT NOT NULL UNIQUE,
+			group_id TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME,
+			max_uses INTEGER,
+			uses INTEGER NOT NULL DEFAULT 0,
+			requires_approval BOOLEAN NOT NULL DEFAULT 0,
+			FOREIGN KEY (group_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_group_invites_group ON group_invites (group_id);`,
+		`CREATE TABLE IF NOT EXISTS group_join_requests (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			invite_id TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			user_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			conversation_id TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, key),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS reactions (
+			message_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			emoji TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (message_id, user_id, emoji),
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+		);`,
 		`CREATE TABLE IF NOT EXISTS user_photos (
-			username TEXT PRIMARY KEY,
+			user_id TEXT PRIMARY KEY,
 			photo BLOB,
 			content_type TEXT NOT NULL DEFAULT 'image/jpeg',
-			FOREIGN KEY (username) REFERENCES users(name) ON DELETE CASCADE ON UPDATE CASCADE
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
 		);`,
 
 		`CREATE TABLE IF NOT EXISTS group_photos (
@@ -127,6 +313,14 @@ func New(db *sql.DB) (AppDatabase, error) {
 			content_type TEXT NOT NULL DEFAULT 'image/jpeg',
 			FOREIGN KEY (group_id) REFERENCES conversations(id) ON DELETE CASCADE
 		);`,
+		`CREATE TABLE IF NOT EXISTS blocks (
+			user_id TEXT NOT NULL,
+			blocked_id TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, blocked_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+		);`,
 	}
 
 	for _, stmt := range tables {
@@ -136,19 +330,230 @@ func New(db *sql.DB) (AppDatabase, error) {
 		}
 	}
 
+	// Give the users of older databases an ID, and use it in place of their name in all the other tables (migration).
+	// The indexes on the renamed columns are created here, once they exist.
+	if err := appdb.migrateUserIDs(); err != nil {
+		return nil, fmt.Errorf("error assigning user IDs: %w", err)
+	}
+	for _, stmt := range []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_users_name ON users (name);",
+		"CREATE INDEX IF NOT EXISTS idx_participants_user ON participants (user_id);",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("error creating user indexes: %w", err)
+		}
+	}
+
 	// Add content_type column if it doesn't exist (migration)
 	_, _ = db.Exec("ALTER TABLE user_photos ADD COLUMN content_type TEXT NOT NULL DEFAULT 'image/jpeg';")
 	_, _ = db.Exec("ALTER TABLE group_photos ADD COLUMN content_type TEXT NOT NULL DEFAULT 'image/jpeg';")
 
+	// Add password_hash column if it doesn't exist (migration). Existing users keep a name-only account until they set
+	// a password.
+	_, _ = db.Exec("ALTER TABLE users ADD COLUMN password_hash TEXT;")
+
+	// Add the profile columns if they don't exist (migration). The creation time of existing users is unknown.
+	_, _ = db.Exec("ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';")
+	_, _ = db.Exec("ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';")
+	_, _ = db.Exec("ALTER TABLE users ADD COLUMN created_at DATETIME;")
+
+	// Add the presence columns if they don't exist (migration)
+	_, _ = db.Exec("ALTER TABLE users ADD COLUMN last_seen_at DATETIME;")
+	_, _ = db.Exec("ALTER TABLE users ADD COLUMN hide_presence BOOLEAN NOT NULL DEFAULT 0;")
+
+	// Add edited_at column if it doesn't exist (migration)
+	_, _ = db.Exec("ALTER TABLE messages ADD COLUMN edited_at DATETIME;")
+
+	// Add withheld_from column if it doesn't exist (migration)
+	_, _ = db.Exec("ALTER TABLE messages ADD COLUMN withheld_from TEXT;")
+
+	// Add reply_to column if it doesn't exist (migration). Its index is created here, once the column exists.
+	_, _ = db.Exec("ALTER TABLE messages ADD COLUMN reply_to TEXT;")
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages (reply_to);"); err != nil {
+		return nil, fmt.Errorf("error creating reply index: %w", err)
+	}
+
+	// Add the system message columns if they don't exist (migration)
+	_, _ = db.Exec("ALTER TABLE messages ADD COLUMN system_event TEXT;")
+	_, _ = db.Exec("ALTER TABLE messages ADD COLUMN system_target TEXT;")
+	_, _ = db.Exec("ALTER TABLE messages ADD COLUMN system_name TEXT;")
+
+	// Add the creator and the description of the conversations if they don't exist (migration)
+	_, _ = db.Exec("ALTER TABLE conversations ADD COLUMN description TEXT NOT NULL DEFAULT '';")
+	_, _ = db.Exec("ALTER TABLE conversations ADD COLUMN created_by TEXT REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE;")
+	_, _ = db.Exec("ALTER TABLE conversations ADD COLUMN created_at DATETIME;")
+	if err := appdb.migrateConversationCreators(); err != nil {
+		return nil, fmt.Errorf("error filling the conversation creators: %w", err)
+	}
+
+	// Add the group roles and permissions if they don't exist (migration). Each existing group gets an owner.
+	_, _ = db.Exec("ALTER TABLE participants ADD COLUMN role TEXT NOT NULL DEFAULT 'member';")
+	_, _ = db.Exec("ALTER TABLE conversations ADD COLUMN perm_rename TEXT NOT NULL DEFAULT 'admin';")
+	_, _ = db.Exec("ALTER TABLE conversations ADD COLUMN perm_change_photo TEXT NOT NULL DEFAULT 'admin';")
+	_, _ = db.Exec("ALTER TABLE conversations ADD COLUMN perm_add_members TEXT NOT NULL DEFAULT 'admin';")
+	_, _ = db.Exec("ALTER TABLE conversations ADD COLUMN perm_post TEXT NOT NULL DEFAULT 'member';")
+	if err := appdb.migrateGroupOwners(); err != nil {
+		return nil, fmt.Errorf("error assigning the group owners: %w", err)
+	}
+
+	// Add the read receipt watermarks if they don't exist (migration). Until they read again, existing participants
+	// see all the messages as unread.
+	_, _ = db.Exec("ALTER TABLE participants ADD COLUMN delivered_message_id TEXT;")
+	_, _ = db.Exec("ALTER TABLE participants ADD COLUMN read_message_id TEXT;")
+
+	// Hash the tokens of sessions created before tokens were hashed (migration)
+	if err := appdb.migrateSessionTokens(); err != nil {
+		return nil, fmt.Errorf("error hashing session tokens: %w", err)
+	}
+
+	// Move the old per-user tokens to the sessions table (migration)
+	if err := appdb.migrateUserTokens(); err != nil {
+		return nil, fmt.Errorf("error migrating user tokens to sessions: %w", err)
+	}
+
+	// Create the full-text index of the messages (migration)
+	if err := appdb.migrateSearchIndex(); err != nil {
+		return nil, fmt.Errorf("error creating the message search index: %w", err)
+	}
+
+	// Convert the single comment of the messages to reactions (migration)
+	if err := appdb.migrateMessageComments(); err != nil {
+		return nil, fmt.Errorf("error migrating message comments to reactions: %w", err)
+	}
+
+	// Merge the duplicate direct conversations and key them by their pair of users (migration). The unique index is
+	// created once there are no duplicates left.
+	_, _ = db.Exec("ALTER TABLE conversations ADD COLUMN direct_key TEXT;")
+	if err := appdb.migrateDirectConversations(); err != nil {
+		return nil, fmt.Errorf("error merging direct conversations: %w", err)
+	}
+	if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_conversations_direct_key ON conversations (direct_key);"); err != nil {
+		return nil, fmt.Errorf("error creating direct conversation index: %w", err)
+	}
+
 	// Enable foreign keys
 	_, err := db.Exec("PRAGMA foreign_keys = ON;")
 	if err != nil {
 		return nil, fmt.Errorf("error enabling foreign keys: %w", err)
 	}
 
-	return &appdbimpl{
-		c: db,
-	}, nil
+	return appdb, nil
+}
+
+// legacySessionLifetime is the lifetime of the sessions created from the old per-user tokens.
+const legacySessionLifetime = 30 * 24 * time.Hour
+
+// migrateUserTokens converts the permanent `users.token` column of older databases into one session per user, so
+// that already logged-in clients keep working, and then drops the column.
+func (db *appdbimpl) migrateUserTokens() error {
+	var hasToken int
+	err := db.c.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'token'").Scan(&hasToken)
+	if err != nil || hasToken == 0 {
+		return err
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, token FROM users")
+	if err != nil {
+		return err
+	}
+	type legacyToken struct{ userID, token string }
+	var tokens []legacyToken
+	for rows.Next() {
+		var t legacyToken
+		if err := rows.Scan(&t.userID, &t.token); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		tokens = append(tokens, t)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, t := range tokens {
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO sessions (id, token_hash, user_id, device, created_at, last_used_at, expires_at)
+			VALUES (lower(hex(randomblob(16))), ?, ?, 'legacy', ?, ?, ?)
+		`, db.hashToken(t.token), t.userID, now.Format(time.RFC3339), now.Format(time.RFC3339), now.Add(legacySessionLifetime).Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("ALTER TABLE users DROP COLUMN token")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// migrateSessionTokens replaces the plaintext `sessions.token` column of older databases with `token_hash`. As SQLite
+// cannot drop UNIQUE columns, the table is rebuilt.
+func (db *appdbimpl) migrateSessionTokens() error {
+	var hasToken int
+	err := db.c.QueryRow("SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name = 'token'").Scan(&hasToken)
+	if err != nil || hasToken == 0 {
+		return err
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("ALTER TABLE sessions RENAME TO sessions_plaintext")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(sessionsTable)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, token FROM sessions_plaintext")
+	if err != nil {
+		return err
+	}
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var id, token string
+		if err := rows.Scan(&id, &token); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		hashes[id] = db.hashToken(token)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, hash := range hashes {
+		_, err = tx.Exec(`
+			INSERT INTO sessions (id, token_hash, user_id, device, created_at, last_used_at, expires_at)
+			SELECT id, ?, user_id, device, created_at, last_used_at, expires_at FROM sessions_plaintext WHERE id = ?
+		`, hash, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DROP TABLE sessions_plaintext")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (db *appdbimpl) Ping() error {
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// baselineSchema is the schema of the databases created by the first version of the application, where users were
+// identified by their name and had a single permanent token.
+var baselineSchema = []string{
+	`CREATE TABLE users (
+		name TEXT PRIMARY KEY,
+		token TEXT NOT NULL
+	);`,
+	`CREATE TABLE conversations (
+		id TEXT PRIMARY KEY,
+		is_group BOOLEAN NOT NULL DEFAULT 0,
+		name TEXT
+	);`,
+	`CREATE TABLE participants (
+		conversation_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		PRIMARY KEY (conversation_id, username),
+		FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+		FOREIGN KEY (username) REFERENCES users(name) ON DELETE CASCADE ON UPDATE CASCADE
+	);`,
+	`CREATE TABLE messages (
+		id TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		text TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		deleted BOOLEAN NOT NULL DEFAULT 0,
+		comment TEXT,
+		commented_at DATETIME,
+		forwarded_from TEXT,
+		FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+	);`,
+	`CREATE TABLE user_photos (
+		username TEXT PRIMARY KEY,
+		photo BLOB,
+		content_type TEXT NOT NULL DEFAULT 'image/jpeg',
+		FOREIGN KEY (username) REFERENCES users(name) ON DELETE CASCADE ON UPDATE CASCADE
+	);`,
+	`CREATE TABLE group_photos (
+		group_id TEXT PRIMARY KEY,
+		photo BLOB,
+		content_type TEXT NOT NULL DEFAULT 'image/jpeg',
+		FOREIGN KEY (group_id) REFERENCES conversations(id) ON DELETE CASCADE
+	);`,
+}
+
+// openTestDB creates a database in a temporary file and opens it with New. The legacy statements are run first, to
+// build and fill a database as an older version left it: New then migrates it.
+func openTestDB(t *testing.T, legacy ...string) *appdbimpl {
+	t.Helper()
+	conn, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "wasa.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("opening the database: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	for _, stmt := range legacy {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("building the legacy database: %v\nStatement: %s", err, stmt)
+		}
+	}
+
+	appdb, err := New(conn, []byte("test token key"))
+	if err != nil {
+		t.Fatalf("opening the database: %v", err)
+	}
+	return appdb.(*appdbimpl)
+}
+
+// queryString returns the single string result of query, failing the test on errors.
+func queryString(t *testing.T, db *appdbimpl, query string, args ...any) string {
+	t.Helper()
+	var s string
+	if err := db.c.QueryRow(query, args...).Scan(&s); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return s
+}
+
+// hasTableColumn reports whether table has the given column.
+func hasTableColumn(t *testing.T, db *appdbimpl, table string, column string) bool {
+	t.Helper()
+	return queryString(t, db, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column) != "0"
+}
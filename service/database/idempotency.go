@@ -0,0 +1,36 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateIdempotencyKey is returned by SaveMessage when the sender has already sent a message with the same
+// idempotency key.
+var ErrDuplicateIdempotencyKey = errors.New("idempotency key already used")
+
+// GetIdempotencyKey returns the conversation and the message sent by username with the given idempotency key, or
+// empty strings if there is no such message.
+func (db *appdbimpl) GetIdempotencyKey(username string, key string) (string, string, error) {
+	var conversationID, messageID string
+	err := db.c.QueryRow("SELECT conversation_id, message_id FROM idempotency_keys WHERE user_id = "+userIDOf+" AND key = ?", username, key).Scan(&conversationID, &messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil
+	}
+	return conversationID, messageID, err
+}
+
+// DeleteIdempotencyKey deletes the idempotency key of username if it was used before the given time, so that it can be
+// used again.
+func (db *appdbimpl) DeleteIdempotencyKey(username string, key string, before time.Time) error {
+	_, err := db.c.Exec("DELETE FROM idempotency_keys WHERE user_id = "+userIDOf+" AND key = ? AND julianday(created_at) < julianday(?)",
+		username, key, before.Format(time.RFC3339))
+	return err
+}
+
+// DeleteIdempotencyKeys deletes the idempotency keys used before the given time, which can then be used again.
+func (db *appdbimpl) DeleteIdempotencyKeys(before time.Time) error {
+	_, err := db.c.Exec("DELETE FROM idempotency_keys WHERE julianday(created_at) < julianday(?)", before.Format(time.RFC3339))
+	return err
+}
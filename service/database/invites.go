@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/aaitayev/wasa-homework"
+	"time"
+)
+
+// inviteColumns are the columns read by scanInvite, in order
+var inviteColumns = "id, group_id, " + userNameOf("created_by") + ", created_at, expires_at, max_uses, uses, requires_approval"
+
+// ErrInviteUnavailable is returned by CreateJoinRequest when the invite has expired or run out of uses.
+var ErrInviteUnavailable = errors.New("invite not available")
+
+// usableInvite is the condition on the invites that can still be used at the time given as parameter
+const usableInvite = "(expires_at IS NULL OR julianday(expires_at) > julianday(?)) AND (max_uses IS NULL OR uses < max_uses)"
+
+// CreateGroupInvite stores a new invite. As for sessions, only the hash of invite.Token is saved.
+func (db *appdbimpl) CreateGroupInvite(invite *models.GroupInvite) error {
+	var expiresAt sql.NullString
+	if !invite.ExpiresAt.IsZero() {
+		expiresAt = sql.NullString{String: invite.ExpiresAt.Format(time.RFC3339), Valid: true}
+	}
+	_, err := db.c.Exec(`
+		INSERT INTO group_invites (id, token_hash, group_id, created_by, created_at, expires_at, max_uses, requires_approval)
+		VALUES (?, ?, ?, `+userIDOf+`, ?, ?, NULLIF(?, 0), ?)
+	`, invite.ID, db.hashToken(invite.Token), invite.GroupID, invite.CreatedBy, invite.CreatedAt.Format(time.RFC3339), expiresAt,
+		invite.MaxUses, invite.RequiresApproval)
+	return err
+}
+
+// GetGroupInviteByToken returns the invite identified by token, or nil if there is no such invite. The invite may have
+// expired or run out of uses.
+func (db *appdbimpl) GetGroupInviteByToken(token string) (*models.GroupInvite, error) {
+	invite, err := scanInvite(db.c.QueryRow("SELECT "+inviteColumns+" FROM group_invites WHERE token_hash = ?", db.hashToken(token)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return invite, err
+}
+
+// GetGroupInvites returns the invites of a group that can still be used at the given time, newest first.
+func (db *appdbimpl) GetGroupInvites(groupID string, now time.Time) ([]models.GroupInvite, error) {
+	rows, err := db.c.Query("SELECT "+inviteColumns+" FROM group_invites WHERE group_id = ? AND "+usableInvite+" ORDER BY created_at DESC, rowid DESC",
+		groupID, now.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []models.GroupInvite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *invite)
+	}
+	return invites, rows.Err()
+}
+
+// DeleteGroupInvite revokes an invite of a group. It returns false if there is no such invite.
+func (db *appdbimpl) DeleteGroupInvite(groupID string, id string) (bool, error) {
+	res, err := db.c.Exec("DELETE FROM group_invites WHERE id = ? AND group_id = ?", id, groupID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// UseGroupInvite counts a use of an invite, if it can still be used at the given time. Otherwise, it returns false.
+func (db *appdbimpl) UseGroupInvite(id string, now time.Time) (bool, error) {
+	res, err := db.c.Exec("UPDATE group_invites SET uses = uses + 1 WHERE id = ? AND "+usableInvite, id, now.Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// CreateJoinRequest stores a request to join a group, and counts a use of its invite, in a single transaction. If the
+// invite can no longer be used at the time of the request, it returns ErrInviteUnavailable. If the user has already
+// asked to join, the first request is kept, no use is counted, and it returns false.
+func (db *appdbimpl) CreateJoinRequest(groupID string, request models.JoinRequest) (bool, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO group_join_requests (group_id, user_id, invite_id, created_at) VALUES (?, `+userIDOf+`, ?, ?)
+		ON CONFLICT (group_id, user_id) DO NOTHING
+	`, groupID, request.Username, request.InviteID, request.CreatedAt.Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+
+	res, err = tx.Exec("UPDATE group_invites SET uses = uses + 1 WHERE id = ? AND "+usableInvite, request.InviteID, request.CreatedAt.Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	affected, err = res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, ErrInviteUnavailable
+	}
+	return true, tx.Commit()
+}
+
+// GetJoinRequests returns the pending requests to join a group, oldest first.
+func (db *appdbimpl) GetJoinRequests(groupID string) ([]models.JoinRequest, error) {
+	rows, err := db.c.Query(`
+		SELECT `+userNameOf("user_id")+`, invite_id, created_at FROM group_join_requests
+		WHERE group_id = ? ORDER BY created_at, rowid
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.JoinRequest{}
+	for rows.Next() {
+		var request models.JoinRequest
+		var createdAt string
+		if err := rows.Scan(&request.Username, &request.InviteID, &createdAt); err != nil {
+			return nil, err
+		}
+		request.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+// DeleteJoinRequest removes the request of username to join a group, once approved or rejected. It returns false if
+// there is no such request.
+func (db *appdbimpl) DeleteJoinRequest(groupID string, username string) (bool, error) {
+	res, err := db.c.Exec("DELETE FROM group_join_requests WHERE group_id = ? AND user_id = "+userIDOf, groupID, username)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// scanInvite scans a row made of inviteColumns.
+func scanInvite(row rowScanner) (*models.GroupInvite, error) {
+	var invite models.GroupInvite
+	var createdAt string
+	var expiresAt sql.NullString
+	var maxUses sql.NullInt64
+	if err := row.Scan(&invite.ID, &invite.GroupID, &invite.CreatedBy, &createdAt, &expiresAt, &maxUses, &invite.Uses, &invite.RequiresApproval); err != nil {
+		return nil, err
+	}
+	invite.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	if expiresAt.Valid {
+		invite.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt.String)
+	}
+	invite.MaxUses = int(maxUses.Int64)
+	return &invite, nil
+}
@@ -2,97 +2,332 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"github.com/aaitayev/wasa-homework"
 	"time"
 )
 
+// messageColumns are the columns read by scanMessage, in order, from the messages table aliased as `m`. The users are
+// read by name.
+var messageColumns = "m.id, m.conversation_id, " + userNameOf("m.sender") + ", m.sender, m.text, m.created_at, m.deleted, m.forwarded_from, " +
+	"m.edited_at, m.reply_to, m.system_event, " + userNameOf("m.system_target") + ", m.system_name, " + userNameOf("m.withheld_from")
+
+// visibleToViewer is the condition that the message `m` is not withheld from the user whose name is the parameter.
+const visibleToViewer = "m.withheld_from IS NOT " + userIDOf
+
+// SaveMessage stores a new message. The attachments of the message must be pending attachments uploaded by its
+// sender: they are attached to the message, otherwise ErrAttachmentUnavailable is returned and nothing is saved.
+// If the message has an idempotency key already used by the sender, ErrDuplicateIdempotencyKey is returned and nothing
+// is saved.
 func (db *appdbimpl) SaveMessage(msg *models.Message) error {
-	_, err := db.c.Exec(`
-		INSERT INTO messages (id, conversation_id, sender, text, created_at, deleted, comment, commented_at, forwarded_from)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, msg.ID, msg.ConversationID, msg.SenderID, msg.Text, msg.CreatedAt.Format(time.RFC3339), msg.Deleted, msg.Comment, msg.CommentedAt.Format(time.RFC3339), msg.ForwardedFrom)
-	return err
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertMessage(tx, msg)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (db *appdbimpl) GetMessage(id string) (*models.Message, error) {
-	var msg models.Message
-	var commentedAt sql.NullString
-	var comment sql.NullString
-	var forwardedFrom sql.NullString
-	var createdAtStr string
+// insertMessage inserts msg, as described in SaveMessage.
+func insertMessage(tx *sql.Tx, msg *models.Message) error {
+	var systemEvent, systemTarget, systemName sql.NullString
+	if msg.System != nil {
+		systemEvent = sql.NullString{String: msg.System.Kind, Valid: true}
+		systemTarget = sql.NullString{String: msg.System.Target, Valid: msg.System.Target != ""}
+		systemName = sql.NullString{String: msg.System.Name, Valid: msg.System.Name != ""}
+	}
+	// The ID of the sender is returned along with the row ID
+	var rowID int64
+	err := tx.QueryRow(`
+		INSERT INTO messages (id, conversation_id, sender, text, created_at, deleted, forwarded_from, reply_to, system_event, system_target, system_name, withheld_from)
+		VALUES (?, ?, `+userIDOf+`, ?, ?, ?, ?, NULLIF(?, ''), ?, `+userIDOf+`, ?, `+userIDOf+`)
+		RETURNING rowid, sender
+	`, msg.ID, msg.ConversationID, msg.Sender, msg.Text, msg.CreatedAt.Format(time.RFC3339), msg.Deleted, msg.ForwardedFrom, msg.ReplyTo,
+		systemEvent, systemTarget, systemName, msg.WithheldFrom).Scan(&rowID, &msg.SenderID)
+	if err != nil {
+		return err
+	}
+
+	// Index the text for search. System messages have no text.
+	if msg.System == nil {
+		_, err = tx.Exec("INSERT INTO messages_fts (rowid, text) VALUES (?, ?)", rowID, msg.Text)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The idempotency key must not have been used by the sender
+	if msg.IdempotencyKey != "" {
+		_, err = tx.Exec(`
+			INSERT INTO idempotency_keys (user_id, key, conversation_id, message_id, created_at)
+			VALUES (`+userIDOf+`, ?, ?, ?, ?)
+			ON CONFLICT (user_id, key) DO NOTHING
+		`, msg.Sender, msg.IdempotencyKey, msg.ConversationID, msg.ID, msg.CreatedAt.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		var owner string
+		err = tx.QueryRow("SELECT message_id FROM idempotency_keys WHERE user_id = "+userIDOf+" AND key = ?", msg.Sender, msg.IdempotencyKey).Scan(&owner)
+		if err != nil {
+			return err
+		}
+		if owner != msg.ID {
+			return ErrDuplicateIdempotencyKey
+		}
+	}
+
+	for _, att := range msg.Attachments {
+		res, err := tx.Exec("UPDATE attachments SET message_id = ? WHERE id = ? AND uploader = "+userIDOf+" AND message_id IS NULL", msg.ID, att.ID, msg.Sender)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrAttachmentUnavailable
+		}
+	}
 
-	err := db.c.QueryRow(`
-		SELECT id, conversation_id, sender, text, created_at, deleted, comment, commented_at, forwarded_from
-		FROM messages WHERE id = ?
-	`, id).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Text, &createdAtStr, &msg.Deleted, &comment, &commentedAt, &forwardedFrom)
+	return nil
+}
 
+func (db *appdbimpl) GetMessage(id string) (*models.Message, error) {
+	msg, err := scanMessage(db.c.QueryRow("SELECT "+messageColumns+" FROM messages m WHERE m.id = ?", id))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	return msg, nil
+}
 
-	msg.CreatedAt, _ = time.Parse(time.RFC3339, createdAtStr)
-	if comment.Valid {
-		msg.Comment = comment.String
+// DeleteMessage marks a message as deleted, and removes it from the search index.
+func (db *appdbimpl) DeleteMessage(id string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
 	}
-	if commentedAt.Valid {
-		msg.CommentedAt, _ = time.Parse(time.RFC3339, commentedAt.String)
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE messages SET deleted = 1 WHERE id = ?", id)
+	if err != nil {
+		return err
 	}
-	if forwardedFrom.Valid {
-		msg.ForwardedFrom = forwardedFrom.String
+	_, err = tx.Exec("DELETE FROM messages_fts WHERE rowid = (SELECT rowid FROM messages WHERE id = ?)", id)
+	if err != nil {
+		return err
 	}
 
-	return &msg, nil
+	return tx.Commit()
 }
 
-func (db *appdbimpl) DeleteMessage(id string) error {
-	_, err := db.c.Exec("UPDATE messages SET deleted = 1 WHERE id = ?", id)
-	return err
+// GetMessagesPage returns at most limit messages of a conversation, in chronological order. If before is a message
+// ID, the messages immediately preceding it are returned; if after is a message ID, the ones immediately following it;
+// otherwise, the most recent ones. The boolean result reports whether there are more messages beyond the page in the
+// direction of the request (older ones for before or no cursor, newer ones for after). The messages withheld from
+// viewer are left out.
+// Messages are ordered by creation time and then by insertion order, so the cursors are stable.
+func (db *appdbimpl) GetMessagesPage(conversationID string, viewer string, before string, after string, limit int) ([]models.Message, bool, error) {
+	var rows *sql.Rows
+	var err error
+	switch {
+	case after != "":
+		rows, err = db.c.Query("SELECT "+messageColumns+` FROM messages m
+			WHERE m.conversation_id = ? AND `+visibleToViewer+`
+				AND (m.created_at, m.rowid) > (SELECT created_at, rowid FROM messages WHERE id = ?)
+			ORDER BY m.created_at ASC, m.rowid ASC LIMIT ?
+		`, conversationID, viewer, after, limit+1)
+	case before != "":
+		rows, err = db.c.Query("SELECT "+messageColumns+` FROM messages m
+			WHERE m.conversation_id = ? AND `+visibleToViewer+`
+				AND (m.created_at, m.rowid) < (SELECT created_at, rowid FROM messages WHERE id = ?)
+			ORDER BY m.created_at DESC, m.rowid DESC LIMIT ?
+		`, conversationID, viewer, before, limit+1)
+	default:
+		rows, err = db.c.Query("SELECT "+messageColumns+` FROM messages m
+			WHERE m.conversation_id = ? AND `+visibleToViewer+`
+			ORDER BY m.created_at DESC, m.rowid DESC LIMIT ?
+		`, conversationID, viewer, limit+1)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	messages := make([]models.Message, 0, limit+1)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, *msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// The extra row only tells whether there is more
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	// Backward pages are read newest first
+	if after == "" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, hasMore, nil
 }
 
-func (db *appdbimpl) GetMessages(conversationID string) ([]models.Message, error) {
+// EditMessage replaces the text of a message, keeping the previous text in its revision history.
+func (db *appdbimpl) EditMessage(id string, text string, editedAt time.Time) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO message_revisions (message_id, text, created_at, replaced_at)
+		SELECT id, text, COALESCE(edited_at, created_at), ? FROM messages WHERE id = ?
+	`, editedAt.Format(time.RFC3339), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE messages SET text = ?, edited_at = ? WHERE id = ?", text, editedAt.Format(time.RFC3339), id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE messages_fts SET text = ? WHERE rowid = (SELECT rowid FROM messages WHERE id = ?)", text, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetMessageRevisions returns the versions of the text of a message, oldest first. The last one is the current text.
+// If the message does not exist, the result is empty.
+func (db *appdbimpl) GetMessageRevisions(id string) ([]models.MessageRevision, error) {
 	rows, err := db.c.Query(`
-		SELECT id, conversation_id, sender, text, created_at, deleted, comment, commented_at, forwarded_from
-		FROM messages WHERE conversation_id = ? ORDER BY created_at ASC
-	`, conversationID)
+		SELECT text, created_at, replaced_at FROM (
+			SELECT text, created_at, replaced_at, rowid AS seq FROM message_revisions WHERE message_id = ?1
+			UNION ALL
+			SELECT text, COALESCE(edited_at, created_at), NULL, NULL FROM messages WHERE id = ?1
+		)
+		ORDER BY seq IS NULL, seq
+	`, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []models.Message
+	var revisions []models.MessageRevision
 	for rows.Next() {
-		var msg models.Message
-		var commentedAt sql.NullString
-		var comment sql.NullString
-		var forwardedFrom sql.NullString
-		var createdAtStr string
+		var rev models.MessageRevision
+		var createdAt string
+		var replacedAt sql.NullString
+		if err := rows.Scan(&rev.Text, &createdAt, &replacedAt); err != nil {
+			return nil, err
+		}
+		rev.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		if replacedAt.Valid {
+			rev.ReplacedAt, _ = time.Parse(time.RFC3339, replacedAt.String)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// GetReplies returns the messages replying to a message, in chronological order, except those withheld from viewer.
+func (db *appdbimpl) GetReplies(id string, viewer string) ([]models.Message, error) {
+	rows, err := db.c.Query("SELECT "+messageColumns+" FROM messages m WHERE m.reply_to = ? AND "+visibleToViewer+
+		" ORDER BY m.created_at ASC, m.rowid ASC", id, viewer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Text, &createdAtStr, &msg.Deleted, &comment, &commentedAt, &forwardedFrom)
+	replies := []models.Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
+		replies = append(replies, *msg)
+	}
+	return replies, rows.Err()
+}
 
-		msg.CreatedAt, _ = time.Parse(time.RFC3339, createdAtStr)
-		if comment.Valid {
-			msg.Comment = comment.String
-		}
-		if commentedAt.Valid {
-			msg.CommentedAt, _ = time.Parse(time.RFC3339, commentedAt.String)
-		}
-		if forwardedFrom.Valid {
-			msg.ForwardedFrom = forwardedFrom.String
-		}
+// GetQuotes returns the sender and the text of the given messages, to be quoted by their replies. The text of deleted
+// messages is not returned. Messages that do not exist are not in the result.
+func (db *appdbimpl) GetQuotes(messageIDs []string) (map[string]models.Quote, error) {
+	quotes := make(map[string]models.Quote, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return quotes, nil
+	}
+	ids, err := json.Marshal(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.c.Query(`
+		SELECT m.id, `+userNameOf("m.sender")+`, m.sender, CASE WHEN m.deleted THEN '' ELSE m.text END, m.deleted
+		FROM messages m WHERE m.id IN (SELECT value FROM json_each(?))
+	`, string(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		messages = append(messages, msg)
+	for rows.Next() {
+		var q models.Quote
+		if err := rows.Scan(&q.MessageID, &q.Sender, &q.SenderID, &q.Text, &q.Deleted); err != nil {
+			return nil, err
+		}
+		quotes[q.MessageID] = q
 	}
-	return messages, rows.Err()
+	return quotes, rows.Err()
 }
 
-func (db *appdbimpl) UpdateMessageComment(id string, comment string, commentedAt time.Time) error {
-	_, err := db.c.Exec("UPDATE messages SET comment = ?, commented_at = ? WHERE id = ?", comment, commentedAt.Format(time.RFC3339), id)
-	return err
+// scanMessage scans a row made of messageColumns, followed by the extra columns, if any.
+func scanMessage(row rowScanner, extra ...any) (*models.Message, error) {
+	var msg models.Message
+	var forwardedFrom sql.NullString
+	var editedAt sql.NullString
+	var replyTo sql.NullString
+	var systemEvent, systemTarget, systemName sql.NullString
+	var withheldFrom sql.NullString
+	var createdAtStr string
+
+	dest := append([]any{&msg.ID, &msg.ConversationID, &msg.Sender, &msg.SenderID, &msg.Text, &createdAtStr, &msg.Deleted, &forwardedFrom, &editedAt, &replyTo,
+		&systemEvent, &systemTarget, &systemName, &withheldFrom}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.CreatedAt, _ = time.Parse(time.RFC3339, createdAtStr)
+	if forwardedFrom.Valid {
+		msg.ForwardedFrom = forwardedFrom.String
+	}
+	if editedAt.Valid {
+		msg.EditedAt, _ = time.Parse(time.RFC3339, editedAt.String)
+	}
+	msg.ReplyTo = replyTo.String
+	msg.WithheldFrom = withheldFrom.String
+	if systemEvent.Valid {
+		msg.System = &models.SystemEvent{Kind: systemEvent.String, Target: systemTarget.String, Name: systemName.String}
+	}
+	return &msg, nil
 }
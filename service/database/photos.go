@@ -3,26 +3,43 @@ package database
 import (
 	"database/sql"
 	"errors"
+
+	"github.com/aaitayev/wasa-homework"
 )
 
 func (db *appdbimpl) SetUserPhoto(username string, photo []byte, contentType string) error {
-	_, err := db.c.Exec("INSERT INTO user_photos (username, photo, content_type) VALUES (?, ?, ?) ON CONFLICT(username) DO UPDATE SET photo=excluded.photo, content_type=excluded.content_type", username, photo, contentType)
+	_, err := db.c.Exec("INSERT INTO user_photos (user_id, photo, content_type) VALUES ("+userIDOf+", ?, ?) ON CONFLICT(user_id) DO UPDATE SET photo=excluded.photo, content_type=excluded.content_type", username, photo, contentType)
 	return err
 }
 
 func (db *appdbimpl) GetUserPhoto(username string) ([]byte, string, error) {
 	var photo []byte
 	var contentType string
-	err := db.c.QueryRow("SELECT photo, content_type FROM user_photos WHERE username = ?", username).Scan(&photo, &contentType)
+	err := db.c.QueryRow("SELECT photo, content_type FROM user_photos WHERE user_id = "+userIDOf, username).Scan(&photo, &contentType)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, "", nil
 	}
 	return photo, contentType, err
 }
 
-func (db *appdbimpl) SetGroupPhoto(groupID string, photo []byte, contentType string) error {
-	_, err := db.c.Exec("INSERT INTO group_photos (group_id, photo, content_type) VALUES (?, ?, ?) ON CONFLICT(group_id) DO UPDATE SET photo=excluded.photo, content_type=excluded.content_type", groupID, photo, contentType)
-	return err
+// SetGroupPhoto sets the photo of a group and stores record, the system message of the change, in a single
+// transaction.
+func (db *appdbimpl) SetGroupPhoto(groupID string, photo []byte, contentType string, record *models.Message) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO group_photos (group_id, photo, content_type) VALUES (?, ?, ?) ON CONFLICT(group_id) DO UPDATE SET photo=excluded.photo, content_type=excluded.content_type", groupID, photo, contentType)
+	if err != nil {
+		return err
+	}
+	err = insertMessage(tx, record)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (db *appdbimpl) GetGroupPhoto(groupID string) ([]byte, string, error) {
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/aaitayev/wasa-homework"
+	"time"
+)
+
+// AddReaction adds the reaction of a user to a message. Adding the same reaction twice has no effect.
+func (db *appdbimpl) AddReaction(messageID string, username string, emoji string, createdAt time.Time) error {
+	_, err := db.c.Exec(`
+		INSERT OR IGNORE INTO reactions (message_id, user_id, emoji, created_at) VALUES (?, `+userIDOf+`, ?, ?)
+	`, messageID, username, emoji, createdAt.Format(time.RFC3339))
+	return err
+}
+
+// RemoveReaction removes the reaction of a user to a message, and reports whether there was such a reaction.
+func (db *appdbimpl) RemoveReaction(messageID string, username string, emoji string) (bool, error) {
+	res, err := db.c.Exec("DELETE FROM reactions WHERE message_id = ? AND user_id = "+userIDOf+" AND emoji = ?", messageID, username, emoji)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// GetReactions returns the reactions to the given messages, grouped by emoji, in the order in which each emoji was
+// first used. Messages without reactions are not in the result.
+func (db *appdbimpl) GetReactions(messageIDs []string) (map[string][]models.Reaction, error) {
+	reactions := make(map[string][]models.Reaction)
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+	ids, err := json.Marshal(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.c.Query(`
+		SELECT message_id, emoji, COUNT(*), json_group_array(name)
+		FROM (
+			SELECT r.rowid AS seq, r.message_id, u.name, r.emoji, r.created_at FROM reactions r JOIN users u ON u.id = r.user_id
+			WHERE r.message_id IN (SELECT value FROM json_each(?))
+			ORDER BY r.created_at, seq
+		)
+		GROUP BY message_id, emoji
+		ORDER BY MIN(created_at), MIN(seq)
+	`, string(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, users string
+		var r models.Reaction
+		if err := rows.Scan(&messageID, &r.Emoji, &r.Count, &users); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(users), &r.Users); err != nil {
+			return nil, err
+		}
+		reactions[messageID] = append(reactions[messageID], r)
+	}
+	return reactions, rows.Err()
+}
+
+// migrateMessageComments converts the single `comment` of the messages of older databases into reactions, and then
+// drops the `comment` and `commented_at` columns. As the author of a comment was not recorded, it is attributed to
+// the other participant in two-person conversations, and to the sender of the message otherwise.
+func (db *appdbimpl) migrateMessageComments() error {
+	var hasComment int
+	err := db.c.QueryRow("SELECT COUNT(*) FROM pragma_table_info('messages') WHERE name = 'comment'").Scan(&hasComment)
+	if err != nil || hasComment == 0 {
+		return err
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO reactions (message_id, user_id, emoji, created_at)
+		SELECT m.id,
+			COALESCE((
+				SELECT p.user_id FROM participants p
+				WHERE p.conversation_id = m.conversation_id AND p.user_id != m.sender
+					AND (SELECT COUNT(*) FROM participants pc WHERE pc.conversation_id = m.conversation_id) = 2
+			), m.sender),
+			m.comment,
+			CASE WHEN m.commented_at IS NULL OR m.commented_at < '1970' THEN m.created_at ELSE m.commented_at END
+		FROM messages m
+		WHERE m.comment IS NOT NULL AND m.comment != ''
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("ALTER TABLE messages DROP COLUMN comment")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE messages DROP COLUMN commented_at")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
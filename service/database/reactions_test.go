@@ -0,0 +1,49 @@
+package database
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMigrateMessageComments(t *testing.T) {
+	db := openTestDB(t, append(baselineSchema,
+		"INSERT INTO users (name, token) VALUES ('alice', 't1'), ('bob', 't2'), ('carol', 't3')",
+		"INSERT INTO conversations (id, is_group, name) VALUES ('direct', 0, NULL), ('group', 1, 'Friends')",
+		"INSERT INTO participants (conversation_id, username) VALUES ('direct', 'alice'), ('direct', 'bob'), "+
+			"('group', 'alice'), ('group', 'bob'), ('group', 'carol')",
+		"INSERT INTO messages (id, conversation_id, sender, text, created_at, comment, commented_at) VALUES "+
+			"('m1', 'direct', 'alice', 'hi', '2024-01-01T10:00:00Z', '👍', '2024-01-01T11:00:00Z'), "+
+			"('m2', 'group', 'alice', 'hello', '2024-01-01T10:00:00Z', '❤️', NULL), "+
+			"('m3', 'group', 'bob', 'no comment', '2024-01-01T10:00:00Z', NULL, NULL)",
+	)...)
+
+	reactions, err := db.GetReactions([]string{"m1", "m2", "m3"})
+	if err != nil {
+		t.Fatalf("getting the reactions: %v", err)
+	}
+
+	// In a direct conversation, the comment is attributed to the other participant; otherwise, to the sender
+	for _, want := range []struct{ message, emoji, user string }{{"m1", "👍", "bob"}, {"m2", "❤️", "alice"}} {
+		got := reactions[want.message]
+		if len(got) != 1 || got[0].Emoji != want.emoji || !slices.Equal(got[0].Users, []string{want.user}) {
+			t.Errorf("reactions of %s: got %+v, want %s by %s", want.message, got, want.emoji, want.user)
+		}
+	}
+	if len(reactions["m3"]) != 0 {
+		t.Errorf("reactions of m3: got %+v, want none", reactions["m3"])
+	}
+
+	// The reaction keeps the time of the comment, or of the message if it was not recorded
+	if at := queryString(t, db, "SELECT created_at FROM reactions WHERE message_id = 'm1'"); at != "2024-01-01T11:00:00Z" {
+		t.Errorf("reaction to m1 created at %s", at)
+	}
+	if at := queryString(t, db, "SELECT created_at FROM reactions WHERE message_id = 'm2'"); at != "2024-01-01T10:00:00Z" {
+		t.Errorf("reaction to m2 created at %s", at)
+	}
+
+	for _, column := range []string{"comment", "commented_at"} {
+		if hasTableColumn(t, db, "messages", column) {
+			t.Errorf("messages.%s was not dropped", column)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/aaitayev/wasa-homework"
+)
+
+// Read receipts are stored as two watermarks per participant: the last message delivered to them and the last message
+// they have read. Messages up to (and including) a watermark, in conversation order, are delivered or read.
+
+// MarkConversationsDelivered moves the delivered watermark of the user to the last message of each of the given
+// conversations.
+func (db *appdbimpl) MarkConversationsDelivered(username string, conversationIDs []string) error {
+	if len(conversationIDs) == 0 {
+		return nil
+	}
+	ids, err := json.Marshal(conversationIDs)
+	if err != nil {
+		return err
+	}
+	_, err = db.c.Exec(`
+		UPDATE participants SET delivered_message_id = (
+			SELECT m.id FROM messages m WHERE m.conversation_id = participants.conversation_id
+			ORDER BY m.created_at DESC, m.rowid DESC LIMIT 1
+		)
+		WHERE user_id = `+userIDOf+` AND conversation_id IN (SELECT value FROM json_each(?))
+	`, username, string(ids))
+	return err
+}
+
+// MarkConversationRead moves the read watermark of the user to messageID, which must be a message of the
+// conversation. Watermarks never move backwards; the delivered watermark follows the read one.
+func (db *appdbimpl) MarkConversationRead(conversationID string, username string, messageID string) error {
+	_, err := db.c.Exec(`
+		UPDATE participants SET
+			read_message_id = ?1,
+			delivered_message_id = CASE
+				WHEN delivered_message_id IS NULL
+					OR (SELECT created_at, rowid FROM messages WHERE id = delivered_message_id) < (SELECT created_at, rowid FROM messages WHERE id = ?1)
+				THEN ?1 ELSE delivered_message_id END
+		WHERE conversation_id = ?2 AND user_id = (SELECT id FROM users WHERE name = ?3) AND (
+			read_message_id IS NULL
+			OR (SELECT created_at, rowid FROM messages WHERE id = read_message_id) < (SELECT created_at, rowid FROM messages WHERE id = ?1)
+		)
+	`, messageID, conversationID, username)
+	return err
+}
+
+// GetMessageReceipts returns, for each of the given messages, how many of the other participants of its conversation
+// have received and read it. A message is never received by the participant it is withheld from. Messages that do not
+// exist are not in the result.
+func (db *appdbimpl) GetMessageReceipts(messageIDs []string) (map[string]models.MessageReceipts, error) {
+	receipts := make(map[string]models.MessageReceipts, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return receipts, nil
+	}
+	ids, err := json.Marshal(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.c.Query(`
+		SELECT m.id, COUNT(p.user_id),
+			COUNT(CASE WHEN p.user_id IS NOT m.withheld_from
+				AND (m.created_at, m.rowid) <= (SELECT created_at, rowid FROM messages WHERE id = p.delivered_message_id) THEN 1 END),
+			COUNT(CASE WHEN p.user_id IS NOT m.withheld_from
+				AND (m.created_at, m.rowid) <= (SELECT created_at, rowid FROM messages WHERE id = p.read_message_id) THEN 1 END)
+		FROM messages m
+		LEFT JOIN participants p ON p.conversation_id = m.conversation_id AND p.user_id != m.sender
+		WHERE m.id IN (SELECT value FROM json_each(?))
+		GROUP BY m.id
+	`, string(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var r models.MessageReceipts
+		if err := rows.Scan(&id, &r.Recipients, &r.Delivered, &r.Read); err != nil {
+			return nil, err
+		}
+		receipts[id] = r
+	}
+	return receipts, rows.Err()
+}
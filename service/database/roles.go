@@ -0,0 +1,168 @@
+package database
+
+import (
+	"errors"
+	"github.com/aaitayev/wasa-homework"
+	"time"
+)
+
+// ErrOwnershipChanged is returned by TransferOwnership when `from` is no longer the owner of the group, or `to` is no
+// longer one of its participants.
+var ErrOwnershipChanged = errors.New("group ownership changed")
+
+func (db *appdbimpl) SetParticipantRole(conversationID string, username string, role string) error {
+	_, err := db.c.Exec("UPDATE participants SET role = ? WHERE conversation_id = ? AND user_id = "+userIDOf, role, conversationID, username)
+	return err
+}
+
+// TransferOwnership makes `to` the owner of a group, and its current owner `from` an admin. It returns
+// ErrOwnershipChanged, and changes nothing, if `from` is not the owner or `to` is not a participant.
+func (db *appdbimpl) TransferOwnership(conversationID string, from string, to string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE participants SET role = ? WHERE conversation_id = ? AND role = ? AND user_id = "+userIDOf, models.RoleAdmin, conversationID, models.RoleOwner, from)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected != 1 {
+		return ErrOwnershipChanged
+	}
+	res, err = tx.Exec("UPDATE participants SET role = ? WHERE conversation_id = ? AND user_id = "+userIDOf, models.RoleOwner, conversationID, to)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected != 1 {
+		return ErrOwnershipChanged
+	}
+	return tx.Commit()
+}
+
+// LeaveGroup removes username from a group and stores record, the system message of the departure, in a single
+// transaction. If newOwner is set, it becomes the owner of the group in the same transaction.
+func (db *appdbimpl) LeaveGroup(groupID string, username string, newOwner string, record *models.Message) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM participants WHERE conversation_id = ? AND user_id = "+userIDOf, groupID, username)
+	if err != nil {
+		return err
+	}
+	if newOwner != "" {
+		_, err = tx.Exec("UPDATE participants SET role = ? WHERE conversation_id = ? AND user_id = "+userIDOf, models.RoleOwner, groupID, newOwner)
+		if err != nil {
+			return err
+		}
+	}
+	err = insertMessage(tx, record)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *appdbimpl) SetGroupPermissions(groupID string, permissions models.GroupPermissions) error {
+	_, err := db.c.Exec(`
+		UPDATE conversations SET perm_rename = ?, perm_change_photo = ?, perm_add_members = ?, perm_post = ? WHERE id = ?
+	`, permissions.Rename, permissions.ChangePhoto, permissions.AddMembers, permissions.Post, groupID)
+	return err
+}
+
+// migrateGroupOwners gives an owner to the groups created before roles existed: their creator if still a member,
+// otherwise their oldest member.
+func (db *appdbimpl) migrateGroupOwners() error {
+	_, err := db.c.Exec(`
+		UPDATE participants SET role = 'owner'
+		WHERE rowid IN (
+			SELECT (
+				SELECT p.rowid FROM participants p WHERE p.conversation_id = c.id
+				ORDER BY p.user_id IS (SELECT created_by FROM conversations WHERE id = p.conversation_id) DESC, p.rowid
+				LIMIT 1
+			)
+			FROM conversations c
+			WHERE c.is_group AND NOT EXISTS (
+				SELECT 1 FROM participants o WHERE o.conversation_id = c.id AND o.role = 'owner'
+			)
+		)
+	`)
+	return err
+}
+
+// RemoveFromGroup removes username from a group and stores record, the system message of the removal, in a single
+// transaction. If ban is not nil, the user is also banned from the group; banning a user again updates the ban.
+func (db *appdbimpl) RemoveFromGroup(groupID string, username string, ban *models.GroupBan, record *models.Message) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if ban != nil {
+		_, err = tx.Exec(`
+			INSERT INTO group_bans (group_id, user_id, banned_by, created_at) VALUES (?, `+userIDOf+`, `+userIDOf+`, ?)
+			ON CONFLICT (group_id, user_id) DO UPDATE SET banned_by = excluded.banned_by, created_at = excluded.created_at
+		`, groupID, ban.Username, ban.BannedBy, ban.CreatedAt.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM participants WHERE conversation_id = ? AND user_id = "+userIDOf, groupID, username)
+	if err != nil {
+		return err
+	}
+	err = insertMessage(tx, record)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnbanFromGroup lifts the ban of a user from a group. It returns false if the user was not banned.
+func (db *appdbimpl) UnbanFromGroup(groupID string, username string) (bool, error) {
+	res, err := db.c.Exec("DELETE FROM group_bans WHERE group_id = ? AND user_id = "+userIDOf, groupID, username)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (db *appdbimpl) IsBannedFromGroup(groupID string, username string) (bool, error) {
+	var banned bool
+	err := db.c.QueryRow("SELECT EXISTS (SELECT 1 FROM group_bans WHERE group_id = ? AND user_id = "+userIDOf+")", groupID, username).Scan(&banned)
+	return banned, err
+}
+
+// GetGroupBans returns the bans of a group, most recent first.
+func (db *appdbimpl) GetGroupBans(groupID string) ([]models.GroupBan, error) {
+	rows, err := db.c.Query(`
+		SELECT `+userNameOf("user_id")+`, `+userNameOf("banned_by")+`, created_at FROM group_bans
+		WHERE group_id = ? ORDER BY created_at DESC, rowid DESC
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []models.GroupBan{}
+	for rows.Next() {
+		var ban models.GroupBan
+		var createdAt string
+		if err := rows.Scan(&ban.Username, &ban.BannedBy, &createdAt); err != nil {
+			return nil, err
+		}
+		ban.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aaitayev/wasa-homework"
+)
+
+func TestTransferOwnership(t *testing.T) {
+	db := openTestDB(t)
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		if err := db.CreateUser(&models.User{ID: name + "-id", Name: name}); err != nil {
+			t.Fatalf("creating user %s: %v", name, err)
+		}
+	}
+	group := &models.Conversation{ID: "group", IsGroup: true, Name: "Friends", Participants: []string{"alice", "bob", "carol"},
+		CreatedBy: "alice", CreatedAt: time.Now()}
+	if err := db.CreateGroup(group, nil, ""); err != nil {
+		t.Fatalf("creating the group: %v", err)
+	}
+	owners := func() string {
+		return queryString(t, db, "SELECT group_concat("+userNameOf("user_id")+") FROM participants WHERE conversation_id = 'group' AND role = 'owner'")
+	}
+
+	// A transfer to a user who is not a participant leaves the group with its owner
+	if err := db.TransferOwnership("group", "alice", "dave"); !errors.Is(err, ErrOwnershipChanged) {
+		t.Fatalf("transfer to a non-participant: got %v, want ErrOwnershipChanged", err)
+	}
+	if got := owners(); got != "alice" {
+		t.Errorf("got owners %q, want alice", got)
+	}
+
+	if err := db.TransferOwnership("group", "alice", "bob"); err != nil {
+		t.Fatalf("transferring the ownership: %v", err)
+	}
+
+	// A concurrent transfer by the former owner does not make a second owner
+	if err := db.TransferOwnership("group", "alice", "carol"); !errors.Is(err, ErrOwnershipChanged) {
+		t.Fatalf("transfer by the former owner: got %v, want ErrOwnershipChanged", err)
+	}
+	if got := owners(); got != "bob" {
+		t.Errorf("got owners %q, want bob", got)
+	}
+}
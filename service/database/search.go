@@ -0,0 +1,122 @@
+package database
+
+import (
+	"html"
+	"github.com/aaitayev/wasa-homework"
+	"strings"
+	"time"
+)
+
+// messagesFTSTable is the full-text index of the messages. Its rowid is the rowid of the indexed message; deleted
+// messages are removed from it. It is kept in sync by SaveMessage, EditMessage and DeleteMessage.
+const messagesFTSTable = `CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+	text,
+	tokenize = 'unicode61 remove_diacritics 2'
+);`
+
+// The snippets of the search results mark the matches between these characters (from the Unicode private use area),
+// which are then replaced by HTML tags once the text is escaped.
+const (
+	snippetMatchStart = "\uE000"
+	snippetMatchEnd   = "\uE001"
+)
+
+// SearchFilter restricts the results of SearchMessages. Empty fields are ignored.
+type SearchFilter struct {
+	Sender         string
+	ConversationID string
+	From           time.Time
+	To             time.Time
+}
+
+// SearchMessages returns a page of the messages matching query, newest first, among the conversations of username.
+// Every word of the query must appear in the message; the last one can be a prefix. The snippet of each result is
+// HTML-escaped, with the matches in <mark> tags. The messages withheld from username are left out.
+func (db *appdbimpl) SearchMessages(username string, query string, filter SearchFilter, limit int, offset int) ([]models.SearchResult, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return []models.SearchResult{}, nil
+	}
+
+	var from, to any
+	if !filter.From.IsZero() {
+		from = filter.From.Format(time.RFC3339)
+	}
+	if !filter.To.IsZero() {
+		to = filter.To.Format(time.RFC3339)
+	}
+
+	rows, err := db.c.Query(`
+		SELECT `+messageColumns+`, snippet(messages_fts, 0, ?, ?, '…', 16)
+		FROM messages_fts
+		JOIN messages m ON m.rowid = messages_fts.rowid
+		JOIN participants p ON p.conversation_id = m.conversation_id AND p.user_id = `+userIDOf+`
+		WHERE messages_fts MATCH ? AND NOT m.deleted AND m.withheld_from IS NOT p.user_id
+			AND (? = '' OR m.sender = `+userIDOf+`)
+			AND (? = '' OR m.conversation_id = ?)
+			AND (? IS NULL OR julianday(m.created_at) >= julianday(?))
+			AND (? IS NULL OR julianday(m.created_at) < julianday(?))
+		ORDER BY m.created_at DESC, m.rowid DESC
+		LIMIT ? OFFSET ?
+	`, snippetMatchStart, snippetMatchEnd, username, match,
+		filter.Sender, filter.Sender, filter.ConversationID, filter.ConversationID, from, from, to, to, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]models.SearchResult, 0, limit)
+	for rows.Next() {
+		var snippet string
+		msg, err := scanMessage(rows, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, models.SearchResult{Message: *msg, Snippet: highlightSnippet(snippet)})
+	}
+	return results, rows.Err()
+}
+
+// ftsQuery converts the words typed by a user into an FTS5 query: each word is quoted, so that the FTS5 syntax
+// (operators, columns, etc.) is not interpreted, and the last one matches as a prefix.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// highlightSnippet escapes a snippet for HTML and marks its matches with <mark> tags.
+func highlightSnippet(snippet string) string {
+	return strings.NewReplacer(snippetMatchStart, "<mark>", snippetMatchEnd, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// migrateSearchIndex creates the full-text index of the messages, indexing the existing ones, if it does not exist.
+func (db *appdbimpl) migrateSearchIndex() error {
+	var exists int
+	err := db.c.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'messages_fts'").Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(messagesFTSTable)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO messages_fts (rowid, text) SELECT rowid, text FROM messages WHERE NOT deleted")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
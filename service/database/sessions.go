@@ -0,0 +1,121 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/aaitayev/wasa-homework"
+	"time"
+)
+
+// hashToken returns the keyed hash (HMAC-SHA256) of a session token, which is what is stored in the database. The
+// plaintext token is only known by the client that logged in.
+func (db *appdbimpl) hashToken(token string) string {
+	mac := hmac.New(sha256.New, db.tokenKey)
+	_, _ = mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateSession stores a new session of the user session.UserID. Only the hash of session.Token is saved.
+func (db *appdbimpl) CreateSession(session *models.Session) error {
+	_, err := db.c.Exec(`
+		INSERT INTO sessions (id, token_hash, user_id, device, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, session.ID, db.hashToken(session.Token), session.UserID, session.Device, session.CreatedAt.Format(time.RFC3339), session.LastUsedAt.Format(time.RFC3339), session.ExpiresAt.Format(time.RFC3339))
+	return err
+}
+
+// GetSessionByToken returns the session identified by token, or nil if there is no such session or if it has expired,
+// either because ExpiresAt has passed or because it has not been used for longer than idleTimeout. Expired sessions
+// are deleted. For valid sessions, LastUsedAt is refreshed.
+func (db *appdbimpl) GetSessionByToken(token string, idleTimeout time.Duration) (*models.Session, error) {
+	tokenHash := db.hashToken(token)
+
+	session, err := scanSession(db.c.QueryRow(`
+		SELECT `+sessionColumns+`
+		FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.token_hash = ?
+	`, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(session.ExpiresAt) || now.Sub(session.LastUsedAt) > idleTimeout {
+		_, err = db.c.Exec("DELETE FROM sessions WHERE id = ?", session.ID)
+		return nil, err
+	}
+
+	session.LastUsedAt = now
+	_, err = db.c.Exec("UPDATE sessions SET last_used_at = ? WHERE id = ?", now.Format(time.RFC3339), session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (db *appdbimpl) GetSession(id string) (*models.Session, error) {
+	session, err := scanSession(db.c.QueryRow(`
+		SELECT `+sessionColumns+`
+		FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.id = ?
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return session, err
+}
+
+func (db *appdbimpl) GetUserSessions(username string) ([]models.Session, error) {
+	rows, err := db.c.Query(`
+		SELECT `+sessionColumns+`
+		FROM sessions s JOIN users u ON u.id = s.user_id WHERE u.name = ? ORDER BY s.last_used_at DESC
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+func (db *appdbimpl) DeleteSession(id string) error {
+	_, err := db.c.Exec("DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// sessionColumns are the columns read by scanSession, in order, from sessions `s` joined with the users `u` they
+// belong to
+const sessionColumns = "s.id, s.user_id, u.name, s.device, s.created_at, s.last_used_at, s.expires_at"
+
+// scanSession scans a row made of sessionColumns.
+func scanSession(row rowScanner) (*models.Session, error) {
+	var session models.Session
+	var createdAtStr, lastUsedAtStr, expiresAtStr string
+
+	err := row.Scan(&session.ID, &session.UserID, &session.Username, &session.Device, &createdAtStr, &lastUsedAtStr, &expiresAtStr)
+	if err != nil {
+		return nil, err
+	}
+
+	session.CreatedAt, _ = time.Parse(time.RFC3339, createdAtStr)
+	session.LastUsedAt, _ = time.Parse(time.RFC3339, lastUsedAtStr)
+	session.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAtStr)
+	return &session, nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrateUserTokens(t *testing.T) {
+	db := openTestDB(t, append(baselineSchema,
+		"INSERT INTO users (name, token) VALUES ('alice', 'alice-token'), ('bob', 'bob-token')",
+	)...)
+
+	// Each user has a legacy session, found with their old token
+	for _, user := range []struct{ name, token string }{{"alice", "alice-token"}, {"bob", "bob-token"}} {
+		session, err := db.GetSessionByToken(user.token, time.Hour)
+		if err != nil {
+			t.Fatalf("getting the session of %s: %v", user.name, err)
+		}
+		if session == nil {
+			t.Fatalf("the token of %s has no session", user.name)
+		}
+		if session.Username != user.name || session.Device != "legacy" {
+			t.Errorf("session of %s: got user %q, device %q", user.name, session.Username, session.Device)
+		}
+		if !session.ExpiresAt.After(time.Now().Add(legacySessionLifetime - time.Hour)) {
+			t.Errorf("session of %s expires at %v", user.name, session.ExpiresAt)
+		}
+	}
+
+	// The tokens are no longer stored with the users, nor in plaintext
+	if hasTableColumn(t, db, "users", "token") {
+		t.Error("users.token was not dropped")
+	}
+	if n := queryString(t, db, "SELECT COUNT(*) FROM sessions WHERE token_hash IN ('alice-token', 'bob-token')"); n != "0" {
+		t.Errorf("%s tokens are stored in plaintext", n)
+	}
+
+	// Running the migration again changes nothing
+	if err := db.migrateUserTokens(); err != nil {
+		t.Fatalf("migrating again: %v", err)
+	}
+	if n := queryString(t, db, "SELECT COUNT(*) FROM sessions"); n != "2" {
+		t.Errorf("got %s sessions, want 2", n)
+	}
+}
+
+func TestMigrateSessionTokens(t *testing.T) {
+	now := time.Now()
+	db := openTestDB(t,
+		`CREATE TABLE users (
+			name TEXT PRIMARY KEY,
+			password_hash TEXT
+		);`,
+		`CREATE TABLE sessions (
+			id TEXT PRIMARY KEY,
+			token TEXT NOT NULL UNIQUE,
+			username TEXT NOT NULL,
+			device TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			last_used_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			FOREIGN KEY (username) REFERENCES users(name) ON DELETE CASCADE ON UPDATE CASCADE
+		);`,
+		"INSERT INTO users (name) VALUES ('alice')",
+		"INSERT INTO sessions (id, token, username, device, created_at, last_used_at, expires_at) VALUES ('s1', 'plain-token', 'alice', 'phone', '"+
+			now.Format(time.RFC3339)+"', '"+now.Format(time.RFC3339)+"', '"+now.Add(time.Hour).Format(time.RFC3339)+"')",
+	)
+
+	// The session is kept, and found with its token
+	session, err := db.GetSessionByToken("plain-token", time.Hour)
+	if err != nil {
+		t.Fatalf("getting the session: %v", err)
+	}
+	if session == nil {
+		t.Fatal("the session was lost")
+	}
+	if session.ID != "s1" || session.Username != "alice" || session.Device != "phone" {
+		t.Errorf("got session %q of %q on %q", session.ID, session.Username, session.Device)
+	}
+
+	// Only the hash of the token is stored
+	if hasTableColumn(t, db, "sessions", "token") {
+		t.Error("sessions.token was not dropped")
+	}
+	if hash := queryString(t, db, "SELECT token_hash FROM sessions WHERE id = 's1'"); hash != db.hashToken("plain-token") {
+		t.Errorf("got token hash %q", hash)
+	}
+	if session, err := db.GetSessionByToken(db.hashToken("plain-token"), time.Hour); err != nil || session != nil {
+		t.Errorf("the hash is accepted as a token (%v)", err)
+	}
+}
@@ -2,33 +2,176 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"github.com/aaitayev/wasa-homework"
+	"time"
 )
 
-func (db *appdbimpl) CreateUser(name string, token string) error {
-	_, err := db.c.Exec("INSERT INTO users (name, token) VALUES (?, ?)", name, token)
+// Users are identified by an immutable ID, which is what the other tables store: their name can change, and it is
+// only a unique attribute of the user. The methods of AppDatabase still take and return user names, translated with
+// userIDOf and userNameOf.
+
+// userIDOf is the SQL expression of the ID of the user whose name is the next query parameter. It is NULL if there is
+// no such user, so that rows referencing unknown users are not inserted.
+const userIDOf = "(SELECT id FROM users WHERE name = ?)"
+
+// userNameOf returns the SQL expression of the current name of the user whose ID is in column. The messages of users
+// renamed before users had IDs could not be attributed, and keep the name of their sender at the time.
+func userNameOf(column string) string {
+	return "COALESCE((SELECT name FROM users WHERE id = " + column + "), " + column + ")"
+}
+
+// CreateUser creates a new user. An empty PasswordHash creates a name-only (unprotected) account.
+func (db *appdbimpl) CreateUser(user *models.User) error {
+	_, err := db.c.Exec(`
+		INSERT INTO users (id, name, password_hash, display_name, bio, created_at) VALUES (?, ?, NULLIF(?, ''), ?, ?, ?)
+	`, user.ID, user.Name, user.PasswordHash, user.DisplayName, user.Bio, user.CreatedAt.Format(time.RFC3339))
 	return err
 }
 
+// GetUserByName returns the user with the given name, or nil if there is no such user. Presence is set to when the
+// user was last seen, whether or not they hide it.
 func (db *appdbimpl) GetUserByName(name string) (*models.User, error) {
 	var user models.User
-	err := db.c.QueryRow("SELECT name, token FROM users WHERE name = ?", name).Scan(&user.Name, &user.Token)
+	var passwordHash, createdAt, lastSeenAt sql.NullString
+	err := db.c.QueryRow(`
+		SELECT id, name, password_hash, display_name, bio, created_at, last_seen_at, hide_presence FROM users WHERE name = ?
+	`, name).Scan(&user.ID, &user.Name, &passwordHash, &user.DisplayName, &user.Bio, &createdAt, &lastSeenAt, &user.HidePresence)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
-	return &user, err
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordHash = passwordHash.String
+	if createdAt.Valid {
+		user.CreatedAt, _ = time.Parse(time.RFC3339, createdAt.String)
+	}
+	user.Presence = &models.Presence{}
+	if lastSeenAt.Valid {
+		user.Presence.LastSeen, _ = time.Parse(time.RFC3339, lastSeenAt.String)
+	}
+	return &user, nil
 }
 
-func (db *appdbimpl) GetUserByToken(token string) (string, error) {
-	var name string
-	err := db.c.QueryRow("SELECT name FROM users WHERE token = ?", token).Scan(&name)
-	if errors.Is(err, sql.ErrNoRows) {
-		return "", nil
+// UpdateUserProfile sets the display name, the bio and the presence privacy setting of a user.
+func (db *appdbimpl) UpdateUserProfile(name string, displayName string, bio string, hidePresence bool) error {
+	_, err := db.c.Exec("UPDATE users SET display_name = ?, bio = ?, hide_presence = ? WHERE name = ?",
+		displayName, bio, hidePresence, name)
+	return err
+}
+
+// SetUserLastSeen records the last activity of the user with the given ID. The ID is used because the connections of
+// a user may outlive their name.
+func (db *appdbimpl) SetUserLastSeen(userID string, at time.Time) error {
+	_, err := db.c.Exec("UPDATE users SET last_seen_at = ? WHERE id = ?", at.Format(time.RFC3339), userID)
+	return err
+}
+
+// GetLastSeen returns when the users with the given names were last seen. Users who hide their presence are left out;
+// users who have not been seen since it was recorded map to the zero time.
+func (db *appdbimpl) GetLastSeen(names []string) (map[string]time.Time, error) {
+	list, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.c.Query(`
+		SELECT name, last_seen_at FROM users
+		WHERE name IN (SELECT value FROM json_each(?)) AND NOT hide_presence
+	`, string(list))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastSeen := make(map[string]time.Time)
+	for rows.Next() {
+		var name string
+		var lastSeenAt sql.NullString
+		if err := rows.Scan(&name, &lastSeenAt); err != nil {
+			return nil, err
+		}
+		var t time.Time
+		if lastSeenAt.Valid {
+			t, _ = time.Parse(time.RFC3339, lastSeenAt.String)
+		}
+		lastSeen[name] = t
 	}
-	return name, err
+	return lastSeen, rows.Err()
 }
 
+// GetMissingUsers returns the names, among the given ones, of the users that do not exist.
+func (db *appdbimpl) GetMissingUsers(names []string) ([]string, error) {
+	list, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.c.Query(`
+		SELECT DISTINCT n.value FROM json_each(?) n
+		WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.name = n.value)
+	`, string(list))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		missing = append(missing, name)
+	}
+	return missing, rows.Err()
+}
+
+// GetUserIDs returns the IDs of the users with the given names, by name. Names of users that do not exist are left out.
+func (db *appdbimpl) GetUserIDs(names []string) (map[string]string, error) {
+	list, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.c.Query("SELECT name, id FROM users WHERE name IN (SELECT value FROM json_each(?))", string(list))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]string)
+	for rows.Next() {
+		var name, id string
+		if err := rows.Scan(&name, &id); err != nil {
+			return nil, err
+		}
+		ids[name] = id
+	}
+	return ids, rows.Err()
+}
+
+// SetUserPassword sets the password of a user, and revokes all their sessions except keepSession: the sessions opened
+// with the old password, or without one, are no longer valid.
+func (db *appdbimpl) SetUserPassword(name string, passwordHash string, keepSession string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET password_hash = ? WHERE name = ?", passwordHash, name)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM sessions WHERE user_id = "+userIDOf+" AND id != ?", name, keepSession)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateUserName renames a user. As the other tables reference the ID of the user, nothing else changes.
 func (db *appdbimpl) UpdateUserName(oldName string, newName string) error {
 	_, err := db.c.Exec("UPDATE users SET name = ? WHERE name = ?", newName, oldName)
 	return err
@@ -51,3 +194,91 @@ func (db *appdbimpl) SearchUsers(query string) ([]string, error) {
 	}
 	return users, rows.Err()
 }
+
+// userReferences are the columns referencing users, which store their ID
+var userReferences = []struct{ table, column string }{
+	{"sessions", "user_id"},
+	{"conversations", "created_by"},
+	{"participants", "user_id"},
+	{"messages", "sender"},
+	{"messages", "system_target"},
+	{"attachments", "uploader"},
+	{"group_bans", "user_id"},
+	{"group_bans", "banned_by"},
+	{"group_invites", "created_by"},
+	{"group_join_requests", "user_id"},
+	{"idempotency_keys", "user_id"},
+	{"reactions", "user_id"},
+	{"user_photos", "user_id"},
+}
+
+// migrateUserIDs gives an ID to the users of older databases, where users were identified by their name, and replaces
+// their name with it wherever they are referenced; the `username` columns become `user_id`. Renaming `users.name` to
+// `users.id` first makes SQLite update the foreign keys of the other tables. The columns that older databases do not
+// have yet are created later, and need no change.
+func (db *appdbimpl) migrateUserIDs() error {
+	var hasID int
+	err := db.c.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'id'").Scan(&hasID)
+	if err != nil || hasID > 0 {
+		return err
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		"ALTER TABLE users RENAME COLUMN name TO id",
+		"ALTER TABLE users ADD COLUMN name TEXT NOT NULL DEFAULT ''",
+		"DROP INDEX IF EXISTS idx_participants_username",
+		"CREATE TEMP TABLE user_ids (name TEXT PRIMARY KEY, id TEXT NOT NULL)",
+		"INSERT INTO user_ids (name, id) SELECT id, lower(hex(randomblob(16))) FROM users",
+		"UPDATE users SET name = id, id = (SELECT u.id FROM user_ids u WHERE u.name = users.id)",
+	} {
+		if _, err = tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range userReferences {
+		if ref.column == "user_id" {
+			legacy, err := hasColumn(tx, ref.table, "username")
+			if err != nil {
+				return err
+			}
+			if legacy {
+				_, err = tx.Exec("ALTER TABLE " + ref.table + " RENAME COLUMN username TO user_id")
+				if err != nil {
+					return err
+				}
+			}
+		}
+		exists, err := hasColumn(tx, ref.table, ref.column)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		_, err = tx.Exec("UPDATE " + ref.table + " SET " + ref.column + " = (SELECT u.id FROM user_ids u WHERE u.name = " + ref.table + "." + ref.column + ") " +
+			"WHERE " + ref.column + " IN (SELECT name FROM user_ids)")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DROP TABLE user_ids")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// hasColumn reports whether table has the given column.
+func hasColumn(tx *sql.Tx, table string, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMigrateUserIDs(t *testing.T) {
+	db := openTestDB(t, append(baselineSchema,
+		"INSERT INTO users (name, token) VALUES ('alice', 't1'), ('bob', 't2')",
+		"INSERT INTO conversations (id, is_group, name) VALUES ('c1', 0, '')",
+		"INSERT INTO participants (conversation_id, username) VALUES ('c1', 'alice'), ('c1', 'bob')",
+		"INSERT INTO messages (id, conversation_id, sender, text, created_at) VALUES ('m1', 'c1', 'alice', 'hi', '2024-01-01T10:00:00Z')",
+		"INSERT INTO user_photos (username, photo, content_type) VALUES ('alice', x'89504e47', 'image/png')",
+	)...)
+
+	// Each user gets an ID, distinct from their name
+	alice, err := db.GetUserByName("alice")
+	if err != nil {
+		t.Fatalf("getting alice: %v", err)
+	}
+	if alice == nil || alice.ID == "" || alice.ID == "alice" {
+		t.Fatalf("got user %+v", alice)
+	}
+
+	// The references to the users use their ID
+	if id := queryString(t, db, "SELECT sender FROM messages WHERE id = 'm1'"); id != alice.ID {
+		t.Errorf("message sender is %q, want %q", id, alice.ID)
+	}
+	if n := queryString(t, db, "SELECT COUNT(*) FROM participants WHERE user_id = ?", alice.ID); n != "1" {
+		t.Errorf("alice is in %s conversations, want 1", n)
+	}
+	if hasTableColumn(t, db, "participants", "username") || hasTableColumn(t, db, "user_photos", "username") {
+		t.Error("the username columns were not renamed")
+	}
+	if photo, contentType, err := db.GetUserPhoto("alice"); err != nil || len(photo) != 4 || contentType != "image/png" {
+		t.Errorf("got photo %x of type %q (%v)", photo, contentType, err)
+	}
+
+	// Renaming a user keeps their data
+	if err := db.UpdateUserName("alice", "alicia"); err != nil {
+		t.Fatalf("renaming alice: %v", err)
+	}
+	conv, err := db.GetConversation("c1")
+	if err != nil {
+		t.Fatalf("getting the conversation: %v", err)
+	}
+	if !slices.Contains(conv.Participants, "alicia") || slices.Contains(conv.Participants, "alice") {
+		t.Errorf("got participants %v after the rename", conv.Participants)
+	}
+	msg, err := db.GetMessage("m1")
+	if err != nil {
+		t.Fatalf("getting the message: %v", err)
+	}
+	if msg.Sender != "alicia" || msg.SenderID != alice.ID {
+		t.Errorf("got sender %q (%q) after the rename", msg.Sender, msg.SenderID)
+	}
+
+	// The sessions migrated from the old tokens belong to the same users
+	session, err := db.GetSessionByToken("t1", time.Hour)
+	if err != nil || session == nil || session.UserID != alice.ID {
+		t.Errorf("got session %+v (%v)", session, err)
+	}
+}
@@ -2,23 +2,174 @@ package models
 
 import "time"
 
-// User represents a user in the system
+// User represents a user in the system, and is their public profile. ID never changes, while the user can change
+// Name. CreatedAt is zero for the users created before it was recorded. Presence is nil when the user hides it, and
+// HidePresence, being a setting, is only shown to the user themselves.
 type User struct {
-	Name  string `json:"name"`
-	Token string `json:"identifier"`
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	DisplayName  string    `json:"displayName"`
+	Bio          string    `json:"bio"`
+	CreatedAt    time.Time `json:"createdAt,omitzero"`
+	Presence     *Presence `json:"presence,omitempty"`
+	HidePresence bool      `json:"-"`
+	PasswordHash string    `json:"-"`
 }
 
-// Message represents a single message in a conversation
+// Presence tells whether a user is online, and when they were last active. LastSeen is zero if the user has not been
+// active since it was recorded.
+type Presence struct {
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"lastSeen,omitzero"`
+}
+
+// Session represents a logged-in device of a user. Token is the plaintext bearer token: it is known only when the
+// session is created, as the database stores just its hash.
+type Session struct {
+	ID         string    `json:"id"`
+	Token      string    `json:"-"`
+	UserID     string    `json:"-"`
+	Username   string    `json:"-"`
+	Device     string    `json:"device"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Message represents a single message in a conversation. Sender is the name of the user who sent it, and SenderID their
+// ID, which does not change when they change their name.
 type Message struct {
-	ID             string    `json:"id"`
-	ConversationID string    `json:"conversationId"`
-	SenderID       string    `json:"senderId"`
-	Text           string    `json:"text"`
-	CreatedAt      time.Time `json:"createdAt"`
-	Deleted        bool      `json:"deleted,omitempty"`
-	Comment        string    `json:"comment,omitempty"`
-	CommentedAt    time.Time `json:"commentedAt,omitempty"`
-	ForwardedFrom  string    `json:"forwardedFrom,omitempty"`
+	ID             string       `json:"id"`
+	ConversationID string       `json:"conversationId"`
+	Sender         string       `json:"sender"`
+	SenderID       string       `json:"senderId"`
+	Text           string       `json:"text"`
+	CreatedAt      time.Time    `json:"createdAt"`
+	Deleted        bool         `json:"deleted,omitempty"`
+	EditedAt       time.Time    `json:"editedAt,omitzero"`
+	ForwardedFrom  string       `json:"forwardedFrom,omitempty"`
+	ReplyTo        string       `json:"replyTo,omitempty"`
+	Quote          *Quote       `json:"quote,omitempty"`
+	Status         string       `json:"status,omitempty"`
+	Reactions      []Reaction   `json:"reactions,omitempty"`
+	Attachments    []Attachment `json:"attachments,omitempty"`
+
+	// System is set for the messages recording a change of the conversation, sent by the server on behalf of the user
+	// who made it (the sender). They have no text, and users cannot delete, edit, forward, react or reply to them.
+	System *SystemEvent `json:"system,omitempty"`
+
+	// IdempotencyKey is the key given by the client when sending the message, if any. It is only used when saving the
+	// message.
+	IdempotencyKey string `json:"-"`
+
+	// WithheldFrom is the participant who does not receive the message, because they have blocked its sender. The
+	// sender sees it as a normal message.
+	WithheldFrom string `json:"-"`
+}
+
+// Kinds of system messages
+const (
+	SystemGroupCreated      = "group-created"
+	SystemGroupRenamed      = "group-renamed"
+	SystemGroupPhotoChanged = "group-photo-changed"
+	SystemMemberAdded       = "member-added"
+	SystemMemberJoined      = "member-joined"
+	SystemMemberLeft        = "member-left"
+	SystemMemberRemoved     = "member-removed"
+)
+
+// SystemEvent is the change of a conversation recorded by a system message. Target is the user affected by the change,
+// if any; Name is the name of the group, for group-created and group-renamed.
+type SystemEvent struct {
+	Kind   string `json:"kind"`
+	Target string `json:"target,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// GroupBan records that a user was removed from a group and cannot join it again
+type GroupBan struct {
+	Username  string    `json:"username"`
+	BannedBy  string    `json:"bannedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BlockedUser is a user blocked by the authenticated user
+type BlockedUser struct {
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// GroupInvite is a link to join a group. Token is the plaintext token of the link: it is known only when the invite is
+// created, as the database stores just its hash. A zero ExpiresAt or MaxUses means no limit.
+type GroupInvite struct {
+	ID               string    `json:"id"`
+	Token            string    `json:"token,omitempty"`
+	GroupID          string    `json:"groupId"`
+	CreatedBy        string    `json:"createdBy"`
+	CreatedAt        time.Time `json:"createdAt"`
+	ExpiresAt        time.Time `json:"expiresAt,omitzero"`
+	MaxUses          int       `json:"maxUses,omitempty"`
+	Uses             int       `json:"uses"`
+	RequiresApproval bool      `json:"requiresApproval"`
+}
+
+// JoinRequest is a request to join a group through an invite that requires approval
+type JoinRequest struct {
+	Username  string    `json:"username"`
+	InviteID  string    `json:"inviteId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Attachment is a file sent in a message. Its content is served separately. MessageID is empty while the attachment
+// is pending, i.e. uploaded but not yet sent.
+type Attachment struct {
+	ID          string    `json:"id"`
+	UploaderID  string    `json:"-"`
+	MessageID   string    `json:"-"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	Caption     string    `json:"caption,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Reaction aggregates the reactions to a message with the same emoji
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// MessageReceipts counts the recipients of a message (the other participants of its conversation) who have received
+// and read it
+type MessageReceipts struct {
+	Recipients int `json:"recipients"`
+	Delivered  int `json:"delivered"`
+	Read       int `json:"read"`
+}
+
+// Quote is a preview of the message a reply answers. Sender and SenderID are as in Message, and Text is a snippet of
+// its text, empty if it has been deleted.
+type Quote struct {
+	MessageID string `json:"messageId"`
+	Sender    string `json:"sender"`
+	SenderID  string `json:"senderId"`
+	Text      string `json:"text"`
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+// SearchResult is a message matching a search. Snippet is the part of its text around the matches, HTML-escaped, with
+// the matches in <mark> tags.
+type SearchResult struct {
+	Message
+	Snippet string `json:"snippet"`
+}
+
+// MessageRevision is a version of the text of a message. ReplacedAt is zero for the current version.
+type MessageRevision struct {
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ReplacedAt time.Time `json:"replacedAt,omitzero"`
 }
 
 // Conversation represents a conversation between users
@@ -28,6 +179,63 @@ type Conversation struct {
 	Messages     []Message `json:"messages"`
 	IsGroup      bool      `json:"isGroup,omitempty"`
 	Name         string    `json:"name,omitempty"`
+	Description  string    `json:"description,omitempty"`
+	CreatedBy    string    `json:"createdBy,omitempty"`
+	CreatedAt    time.Time `json:"createdAt,omitzero"`
+
+	// Presence maps the participants to their presence; those who hide it are left out
+	Presence map[string]Presence `json:"presence,omitempty"`
+
+	// Roles maps the participants of a group to their role
+	Roles       map[string]string `json:"-"`
+	Permissions GroupPermissions  `json:"-"`
+}
+
+// Roles of the members of a group, from the least to the most privileged. A group has a single owner.
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+	RoleOwner  = "owner"
+)
+
+// GroupPermissions is the minimum role a member of a group needs for each action
+type GroupPermissions struct {
+	Rename      string `json:"rename"`
+	ChangePhoto string `json:"changePhoto"`
+	AddMembers  string `json:"addMembers"`
+	Post        string `json:"post"`
+}
+
+// Group represents the metadata of a group conversation
+type Group struct {
+	ID          string    `json:"groupId"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedBy   string    `json:"createdBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt,omitzero"`
+	Members     []string  `json:"members"`
+	MemberCount int       `json:"memberCount"`
+
+	Roles       map[string]string `json:"roles"`
+	Permissions GroupPermissions  `json:"permissions"`
+}
+
+// ConversationSummary represents a conversation in the inbox, with its last message
+type ConversationSummary struct {
+	ID                 string       `json:"id"`
+	IsGroup            bool         `json:"isGroup"`
+	Name               string       `json:"name"`
+	Participants       []string     `json:"participants"`
+	LastMessageID      string       `json:"lastMessageId,omitempty"`
+	LastMessageSender  string       `json:"lastMessageSender,omitempty"`
+	LastMessageAt      time.Time    `json:"lastMessageAt"`
+	LastMessageText    string       `json:"lastMessageText"`
+	LastMessageDeleted bool         `json:"lastMessageDeleted,omitempty"`
+	LastMessageSystem  *SystemEvent `json:"lastMessageSystem,omitempty"`
+	UnreadCount        int          `json:"unreadCount"`
+
+	// Presence maps the participants to their presence; those who hide it are left out
+	Presence map[string]Presence `json:"presence,omitempty"`
 }
 
 // Participant represents a user participating in a conversation
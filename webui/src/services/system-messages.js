@@ -0,0 +1,25 @@
+// describeSystemEvent returns the text shown for a system message, which records a change of a conversation made by
+// actor (the sender of the message).
+export function describeSystemEvent(actor, system, myUsername) {
+	const who = (user) => (user === myUsername ? "you" : user);
+	const Who = (user) => (user === myUsername ? "You" : user);
+
+	switch (system.kind) {
+		case "group-created":
+			return `${Who(actor)} created the group "${system.name}"`;
+		case "group-renamed":
+			return `${Who(actor)} renamed the group to "${system.name}"`;
+		case "group-photo-changed":
+			return `${Who(actor)} changed the group photo`;
+		case "member-added":
+			return `${Who(actor)} added ${who(system.target)}`;
+		case "member-joined":
+			return `${Who(actor)} joined with an invite link`;
+		case "member-left":
+			return `${Who(actor)} left`;
+		case "member-removed":
+			return `${Who(actor)} removed ${who(system.target)}`;
+		default:
+			return `${Who(actor)} changed the conversation`;
+	}
+}